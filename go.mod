@@ -8,20 +8,20 @@ require (
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/securecookie v1.1.1
 	github.com/gorilla/sessions v1.2.1
+	github.com/gorilla/websocket v1.4.2
 	github.com/joho/godotenv v1.4.0
+	github.com/jpillora/backoff v1.0.0
 	github.com/preichenberger/go-coinbasepro/v2 v2.1.0
-	github.com/recws-org/recws v1.4.0
 	github.com/rs/zerolog v1.26.1
 	github.com/shopspring/decimal v1.3.1
+	go.uber.org/goleak v1.1.12
 	gorm.io/gorm v1.22.4
 )
 
 require (
 	github.com/google/uuid v1.3.0 // indirect
-	github.com/gorilla/websocket v1.4.2 // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.4 // indirect
-	github.com/jpillora/backoff v1.0.0 // indirect
 	github.com/kballard/go-shellquote v0.0.0-20180428030007-95032a82bc51 // indirect
 	github.com/mattn/go-isatty v0.0.14 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20200410134404-eec4a21b6bb0 // indirect
@@ -40,5 +40,3 @@ require (
 	modernc.org/strutil v1.1.1 // indirect
 	modernc.org/token v1.0.0 // indirect
 )
-
-replace github.com/recws-org/recws v1.4.0 => github.com/sknr/recws v1.3.2-0.20211215115953-fab3c0cb58fd
@@ -0,0 +1,232 @@
+package app
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/sknr/go-coinbasepro-notifier/internal/audit"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"github.com/sknr/go-coinbasepro-notifier/internal/role"
+	"github.com/sknr/go-coinbasepro-notifier/internal/supervisor"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	adminPageSize = 25
+	// adminMaxEvents limits the number of audit events and notification failures shown at once
+	adminMaxEvents = 100
+)
+
+// Sections of the admin console
+const (
+	adminSectionUsers         = "users"
+	adminSectionWatchers      = "watchers"
+	adminSectionAudit         = "audit"
+	adminSectionNotifications = "notifications"
+)
+
+// Operations on a user, which can be triggered within the admin console
+const (
+	adminOpEnable  = "enable"
+	adminOpDisable = "disable"
+	adminOpRestart = "restart"
+	adminOpDelete  = "delete"
+)
+
+// adminUser is a row of the users section
+type adminUser struct {
+	database.UserSettings
+	Role          role.Role
+	WatcherStatus string
+	LastEvent     time.Time
+}
+
+// adminPage holds the data of the admin template. Only the fields of the current section are set.
+type adminPage struct {
+	Admin     TelegramUser
+	Section   string
+	CSRFToken string
+	ReturnTo  string // Path of the current page, to which the actions redirect
+
+	// Users section
+	Query    string
+	Users    []adminUser
+	Total    int64
+	Page     int
+	Pages    int
+	PrevPage int
+	NextPage int
+
+	// Watchers section
+	Watchers []supervisor.WatcherStatus
+
+	// Audit section
+	AuditUser   string
+	AuditAction string
+	AuditSince  string
+	AuditEvents []database.AuditEvent
+
+	// Notifications section
+	NotificationUser string
+	Failures         []database.Notification
+}
+
+// registerAdmin adds the routes of the admin console, which requires the admin role
+func (a *App) registerAdmin(router *mux.Router) {
+	router.Handle("/admin", http.RedirectHandler("/admin/users", http.StatusSeeOther)).Methods(http.MethodGet)
+
+	admin := router.PathPrefix("/admin").Subrouter()
+	admin.Use(a.adminOnly, a.csrfProtect)
+	admin.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
+	admin.HandleFunc("/users", a.adminUsersHandler).Methods(http.MethodGet)
+	admin.HandleFunc("/watchers", a.adminWatchersHandler).Methods(http.MethodGet)
+	admin.HandleFunc("/audit", a.adminAuditHandler).Methods(http.MethodGet)
+	admin.HandleFunc("/notifications", a.adminNotificationsHandler).Methods(http.MethodGet)
+	admin.HandleFunc("/users/{id}/{op:enable|disable|restart|delete}", a.adminUserActionHandler).Methods(http.MethodPost)
+}
+
+// adminOnly is a middleware which only lets users with the admin role of the session pass
+func (a *App) adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, _ := a.sessionStore.Get(r, sessionName)
+		user := getUser(session)
+		if !user.IsAuthenticated {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		if !a.roles.Get(user.ID).AtLeast(role.Admin) {
+			logger.LogWarnf("[%s] User without role %q tries to access %s", user.ID, role.Admin, r.URL.Path)
+			w.WriteHeader(http.StatusForbidden)
+			renderTemplate(w, "error", struct{ ErrorMessage string }{"Access denied"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newAdminPage creates the page of the given section for the admin of the session
+func (a *App) newAdminPage(w http.ResponseWriter, r *http.Request, section string) adminPage {
+	session, _ := a.sessionStore.Get(r, sessionName)
+	return adminPage{
+		Admin:     getUser(session),
+		Section:   section,
+		CSRFToken: a.csrfToken(w, r),
+		ReturnTo:  r.URL.RequestURI(),
+	}
+}
+
+// adminUsersHandler lists the users matching the query parameter q
+func (a *App) adminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	page := a.newAdminPage(w, r, adminSectionUsers)
+	page.Query = strings.TrimSpace(r.URL.Query().Get("q"))
+	page.Page, _ = strconv.Atoi(r.URL.Query().Get("page"))
+	if page.Page < 1 {
+		page.Page = 1
+	}
+
+	var users []database.UserSettings
+	users, page.Total = a.searchUsers(page.Query, (page.Page-1)*adminPageSize, adminPageSize)
+	page.Pages = int((page.Total + adminPageSize - 1) / adminPageSize)
+	if page.Page > 1 {
+		page.PrevPage = page.Page - 1
+	}
+	if page.Page < page.Pages {
+		page.NextPage = page.Page + 1
+	}
+	for _, us := range users {
+		_, status := a.getWatcherStatus(us.TelegramID)
+		page.Users = append(page.Users, adminUser{
+			UserSettings:  us,
+			Role:          a.roles.Get(us.TelegramID),
+			WatcherStatus: status,
+			LastEvent:     a.getLastOrderEventTime(us.TelegramID),
+		})
+	}
+	renderTemplate(w, "admin", page)
+}
+
+// adminWatchersHandler shows the health of all supervised watchers
+func (a *App) adminWatchersHandler(w http.ResponseWriter, r *http.Request) {
+	page := a.newAdminPage(w, r, adminSectionWatchers)
+	page.Watchers = a.supervisor.Statuses()
+	renderTemplate(w, "admin", page)
+}
+
+// adminAuditHandler shows the audit log, filtered by the query parameters user, action and since (YYYY-MM-DD)
+func (a *App) adminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	page := a.newAdminPage(w, r, adminSectionAudit)
+	query := r.URL.Query()
+	page.AuditUser = strings.TrimSpace(query.Get("user"))
+	page.AuditAction = strings.TrimSpace(query.Get("action"))
+	page.AuditSince = query.Get("since")
+
+	filter := audit.Filter{UserID: page.AuditUser, Action: page.AuditAction, Limit: adminMaxEvents}
+	if page.AuditSince != "" {
+		since, err := time.ParseInLocation(dashboardDateLayout, page.AuditSince, time.UTC)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			renderTemplate(w, "error", struct{ ErrorMessage string }{"Invalid since date, expected YYYY-MM-DD"})
+			return
+		}
+		filter.Since = since
+	}
+	page.AuditEvents = a.audit.Query(filter)
+	renderTemplate(w, "admin", page)
+}
+
+// adminNotificationsHandler shows the notifications, which could not be delivered, optionally of a single user
+func (a *App) adminNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	page := a.newAdminPage(w, r, adminSectionNotifications)
+	page.NotificationUser = strings.TrimSpace(r.URL.Query().Get("user"))
+
+	db := a.db.Where("delivered = ?", false)
+	if page.NotificationUser != "" {
+		db = db.Where("telegram_id = ?", page.NotificationUser)
+	}
+	err := db.Order("created_at DESC, id DESC").Limit(adminMaxEvents).Find(&page.Failures).Error
+	logger.LogErrorIfExists(err)
+	renderTemplate(w, "admin", page)
+}
+
+// adminUserActionHandler enables, disables or deletes a user or restarts the watcher and returns to the previous page
+func (a *App) adminUserActionHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := a.sessionStore.Get(r, sessionName)
+	user := getUser(session)
+	actor := audit.WebActor(user.ID, r)
+	vars := mux.Vars(r)
+	telegramID := vars["id"]
+	if !a.mayManage(user.ID, telegramID) {
+		logger.LogWarnf("[%s] Rejected action on user %s with the same or a higher role", user.ID, telegramID)
+		w.WriteHeader(http.StatusForbidden)
+		renderTemplate(w, "error", struct{ ErrorMessage string }{"You are not allowed to manage users with the same or a higher role"})
+		return
+	}
+
+	switch vars["op"] {
+	case adminOpEnable:
+		if a.enableUser(telegramID) {
+			a.audit.Record(actor, audit.ActionUserEnabled, telegramID, "")
+		}
+	case adminOpDisable:
+		if a.disableUser(telegramID) {
+			a.audit.Record(actor, audit.ActionUserDisabled, telegramID, "")
+		}
+	case adminOpRestart:
+		if a.restartWatcher(telegramID) {
+			a.audit.Record(actor, audit.ActionWatcherRestarted, telegramID, "")
+		}
+	case adminOpDelete:
+		if a.deleteUser(telegramID) {
+			a.audit.Record(actor, audit.ActionUserDeleted, telegramID, "")
+		}
+	}
+
+	// Only redirect within the admin console
+	returnTo := r.PostFormValue("return")
+	if !strings.HasPrefix(returnTo, "/admin/") {
+		returnTo = "/admin/users"
+	}
+	http.Redirect(w, r, returnTo, http.StatusSeeOther)
+}
@@ -0,0 +1,410 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/mux"
+	"github.com/sknr/go-coinbasepro-notifier/internal/audit"
+	"github.com/sknr/go-coinbasepro-notifier/internal/coinbase"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"github.com/sknr/go-coinbasepro-notifier/internal/mute"
+	"github.com/sknr/go-coinbasepro-notifier/internal/token"
+	"github.com/sknr/go-coinbasepro-notifier/internal/watcher"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiPrefix = "/api/v1"
+	// apiMaxBodySize limits the size of json request bodies
+	apiMaxBodySize = 64 << 10
+
+	apiDefaultLimit = 100
+	apiMaxLimit     = 1000
+)
+
+// apiHandlerFunc handles an api request of an authenticated user
+type apiHandlerFunc func(w http.ResponseWriter, r *http.Request, user TelegramUser)
+
+// Response bodies of the api
+type (
+	apiError struct {
+		Error string `json:"error"`
+	}
+
+	apiProfile struct {
+		TelegramID string    `json:"telegram_id"`
+		Username   string    `json:"username"`
+		FirstName  string    `json:"first_name"`
+		LastName   string    `json:"last_name"`
+		PhotoURL   string    `json:"photo_url"`
+		Active     bool      `json:"active"`
+		Role       string    `json:"role"`
+		CreatedAt  time.Time `json:"created_at"`
+		UpdatedAt  time.Time `json:"updated_at"`
+	}
+
+	// apiCredentials reveals whether the credentials are configured, but never their values
+	apiCredentials struct {
+		Configured    bool   `json:"configured"`
+		APIKeySuffix  string `json:"api_key_suffix,omitempty"` // Last characters of the api key for recognizing it
+		HasPassphrase bool   `json:"has_passphrase"`
+		HasSecret     bool   `json:"has_secret"`
+	}
+
+	apiMute struct {
+		ProductID string    `json:"product_id"` // Empty for all products
+		Until     time.Time `json:"until"`
+	}
+
+	apiPreferences struct {
+		Mutes []apiMute `json:"mutes"`
+	}
+
+	apiOrderEvent struct {
+		OrderID       string    `json:"order_id"`
+		Time          time.Time `json:"time"`
+		Type          string    `json:"type"`
+		Reason        string    `json:"reason,omitempty"`
+		ProductID     string    `json:"product_id"`
+		Side          string    `json:"side"`
+		OrderType     string    `json:"order_type"`
+		Price         string    `json:"price,omitempty"`
+		Size          string    `json:"size,omitempty"`
+		RemainingSize string    `json:"remaining_size,omitempty"`
+		Funds         string    `json:"funds,omitempty"`
+	}
+
+	apiWatcher struct {
+		Running       bool          `json:"running"`
+		Paused        bool          `json:"paused"`
+		PauseReason   string        `json:"pause_reason,omitempty"`
+		State         watcher.State `json:"state,omitempty"`
+		Connected     bool          `json:"connected"`
+		Since         time.Time     `json:"since"`
+		LastMessageAt time.Time     `json:"last_message_at"`
+		LastError     string        `json:"last_error,omitempty"`
+		LastErrorAt   time.Time     `json:"last_error_at"`
+		Reconnects    int           `json:"reconnects"`
+	}
+)
+
+// Request bodies of the api
+type (
+	apiCredentialsRequest struct {
+		APIKey        string `json:"api_key"`
+		APIPassphrase string `json:"api_passphrase"`
+		APISecret     string `json:"api_secret"`
+	}
+
+	apiMuteRequest struct {
+		ProductID string `json:"product_id"` // Empty for all products
+		Duration  string `json:"duration"`   // Like 30m, 2h, 1d or 1w
+	}
+)
+
+// registerAPI adds the routes of the versioned json api to the router
+func (a *App) registerAPI(router *mux.Router) {
+	api := router.PathPrefix(apiPrefix).Subrouter()
+	api.HandleFunc("/profile", a.apiAuth(a.apiGetProfile)).Methods(http.MethodGet)
+	api.HandleFunc("/profile", a.apiAuth(a.apiDeleteProfile)).Methods(http.MethodDelete)
+	api.HandleFunc("/credentials", a.apiAuth(a.apiGetCredentials)).Methods(http.MethodGet)
+	api.HandleFunc("/credentials", a.apiAuth(a.apiPutCredentials)).Methods(http.MethodPut)
+	api.HandleFunc("/preferences", a.apiAuth(a.apiGetPreferences)).Methods(http.MethodGet)
+	api.HandleFunc("/preferences/mutes", a.apiAuth(a.apiPutMute)).Methods(http.MethodPut)
+	api.HandleFunc("/preferences/mutes", a.apiAuth(a.apiDeleteMute)).Methods(http.MethodDelete)
+	api.HandleFunc("/orders", a.apiAuth(a.apiGetOrders)).Methods(http.MethodGet)
+	api.HandleFunc("/watcher", a.apiAuth(a.apiGetWatcher)).Methods(http.MethodGet)
+	api.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, "Not found")
+	})
+	api.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	})
+}
+
+// apiAuth authenticates the request and passes the user to the given handler.
+// Requests authenticated by a personal api token need the read scope for GET requests and the write scope otherwise.
+func (a *App) apiAuth(fn apiHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if header := r.Header.Get("Authorization"); header != "" {
+			t, err := a.authenticateToken(header)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+				writeAPIError(w, http.StatusUnauthorized, "Invalid token")
+				return
+			}
+			scope := token.ScopeWrite
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				scope = token.ScopeRead
+			}
+			if !token.HasScope(t, scope) {
+				writeAPIError(w, http.StatusForbidden, "Token lacks the "+scope+" scope")
+				return
+			}
+			fn(w, r, TelegramUser{ID: t.TelegramID, IsAuthenticated: true})
+			return
+		}
+
+		session, _ := a.sessionStore.Get(r, sessionName)
+		user := getUser(session)
+		if !user.IsAuthenticated {
+			writeAPIError(w, http.StatusUnauthorized, "Authentication required")
+			return
+		}
+		fn(w, r, user)
+	}
+}
+
+// authenticateToken resolves the personal api token of an "Authorization: Bearer <token>" header
+func (a *App) authenticateToken(header string) (database.APIToken, error) {
+	const scheme = "bearer "
+	if len(header) <= len(scheme) || !strings.EqualFold(header[:len(scheme)], scheme) {
+		return database.APIToken{}, token.ErrInvalidToken
+	}
+	return a.tokens.Authenticate(strings.TrimSpace(header[len(scheme):]))
+}
+
+func (a *App) apiGetProfile(w http.ResponseWriter, _ *http.Request, user TelegramUser) {
+	us, ok := a.apiUserSettings(w, user)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, apiProfile{
+		TelegramID: us.TelegramID,
+		Username:   us.Username,
+		FirstName:  us.FirstName,
+		LastName:   us.LastName,
+		PhotoURL:   us.PhotoURL,
+		Active:     us.Active,
+		Role:       string(a.roles.Get(us.TelegramID)),
+		CreatedAt:  us.CreatedAt,
+		UpdatedAt:  us.UpdatedAt,
+	})
+}
+
+func (a *App) apiDeleteProfile(w http.ResponseWriter, r *http.Request, user TelegramUser) {
+	if _, ok := a.apiUserSettings(w, user); !ok {
+		return
+	}
+	a.deleteProfile(audit.WebActor(user.ID, r), user)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) apiGetCredentials(w http.ResponseWriter, _ *http.Request, user TelegramUser) {
+	us, ok := a.apiUserSettings(w, user)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPICredentials(us))
+}
+
+func (a *App) apiPutCredentials(w http.ResponseWriter, r *http.Request, user TelegramUser) {
+	us, ok := a.apiUserSettings(w, user)
+	if !ok {
+		return
+	}
+	var req apiCredentialsRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.APIKey == "" || req.APIPassphrase == "" || req.APISecret == "" {
+		writeAPIError(w, http.StatusBadRequest, "api_key, api_passphrase and api_secret are required")
+		return
+	}
+	candidate := database.UserSettings{APIKey: req.APIKey, APIPassphrase: req.APIPassphrase, APISecret: req.APISecret}
+	if _, err := coinbase.NewClient(candidate).GetAccounts(); err != nil {
+		logger.LogInfof("[%s] Invalid coinbase pro credentials entered via api: %v", user.ID, err)
+		writeAPIError(w, http.StatusUnprocessableEntity, "Coinbase Pro rejected the credentials: "+err.Error())
+		return
+	}
+
+	us = a.updateAPISettings(audit.WebActor(user.ID, r), us, req.APIKey, req.APIPassphrase, req.APISecret)
+	writeJSON(w, http.StatusOK, newAPICredentials(us))
+}
+
+func (a *App) apiGetPreferences(w http.ResponseWriter, _ *http.Request, user TelegramUser) {
+	if _, ok := a.apiUserSettings(w, user); !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, a.apiPreferences(user.ID))
+}
+
+func (a *App) apiPutMute(w http.ResponseWriter, r *http.Request, user TelegramUser) {
+	if _, ok := a.apiUserSettings(w, user); !ok {
+		return
+	}
+	var req apiMuteRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	duration := defaultMuteDuration
+	if req.Duration != "" {
+		d, err := mute.ParseDuration(req.Duration)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "Invalid duration, expected e.g. 30m, 2h, 1d or 1w of at most one year")
+			return
+		}
+		duration = d
+	}
+	product := strings.ToUpper(req.ProductID)
+	if product != "" && !isProduct(product) {
+		writeAPIError(w, http.StatusBadRequest, "Unknown product")
+		return
+	}
+
+	a.mutes.Mute(user.ID, product, duration)
+	writeJSON(w, http.StatusOK, a.apiPreferences(user.ID))
+}
+
+func (a *App) apiDeleteMute(w http.ResponseWriter, r *http.Request, user TelegramUser) {
+	if _, ok := a.apiUserSettings(w, user); !ok {
+		return
+	}
+	a.mutes.Unmute(user.ID, strings.ToUpper(r.URL.Query().Get("product_id")))
+	writeJSON(w, http.StatusOK, a.apiPreferences(user.ID))
+}
+
+// apiGetOrders returns the order history, newest first.
+// It can be filtered by the query parameters product_id, since and until (RFC 3339) and limit.
+func (a *App) apiGetOrders(w http.ResponseWriter, r *http.Request, user TelegramUser) {
+	if _, ok := a.apiUserSettings(w, user); !ok {
+		return
+	}
+	query := r.URL.Query()
+	db := a.db.Where("telegram_id = ?", user.ID)
+	if product := query.Get("product_id"); product != "" {
+		db = db.Where("product_id = ?", strings.ToUpper(product))
+	}
+	for param, cond := range map[string]string{"since": "time >= ?", "until": "time < ?"} {
+		if value := query.Get(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, "Invalid "+param+" parameter, expected RFC 3339")
+				return
+			}
+			db = db.Where(cond, t)
+		}
+	}
+	limit, err := parseLimit(query.Get("limit"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var events []database.OrderEvent
+	db.Order("time DESC, id DESC").Limit(limit).Find(&events)
+	orders := make([]apiOrderEvent, 0, len(events))
+	for _, e := range events {
+		orders = append(orders, apiOrderEvent{
+			OrderID:       e.OrderID,
+			Time:          e.Time,
+			Type:          e.Type,
+			Reason:        e.Reason,
+			ProductID:     e.ProductID,
+			Side:          e.Side,
+			OrderType:     e.OrderType,
+			Price:         e.Price,
+			Size:          e.Size,
+			RemainingSize: e.RemainingSize,
+			Funds:         e.Funds,
+		})
+	}
+	writeJSON(w, http.StatusOK, orders)
+}
+
+func (a *App) apiGetWatcher(w http.ResponseWriter, _ *http.Request, user TelegramUser) {
+	if _, ok := a.apiUserSettings(w, user); !ok {
+		return
+	}
+	s, running := a.supervisor.Status(user.ID)
+	writeJSON(w, http.StatusOK, apiWatcher{
+		Running:       running && !s.Paused && s.State != watcher.StateStopped,
+		Paused:        s.Paused,
+		PauseReason:   s.PauseReason,
+		State:         s.State,
+		Connected:     s.Connected,
+		Since:         s.Since,
+		LastMessageAt: s.LastMessageAt,
+		LastError:     s.LastError,
+		LastErrorAt:   s.LastErrorAt,
+		Reconnects:    s.Reconnects,
+	})
+}
+
+// apiUserSettings loads the settings of the user. If the user is not registered, an error is sent.
+func (a *App) apiUserSettings(w http.ResponseWriter, user TelegramUser) (database.UserSettings, bool) {
+	var us database.UserSettings
+	a.db.Where("telegram_id = ?", user.ID).Limit(1).Find(&us)
+	if us.TelegramID == "" {
+		writeAPIError(w, http.StatusNotFound, "Profile not found")
+		return us, false
+	}
+	return us, true
+}
+
+// apiPreferences collects the notification preferences of the user
+func (a *App) apiPreferences(telegramID string) apiPreferences {
+	prefs := apiPreferences{Mutes: []apiMute{}}
+	for _, m := range a.mutes.Active(telegramID) {
+		prefs.Mutes = append(prefs.Mutes, apiMute{ProductID: m.ProductID, Until: m.Until})
+	}
+	return prefs
+}
+
+func newAPICredentials(us database.UserSettings) apiCredentials {
+	c := apiCredentials{
+		Configured:    us.APIKey != "" && us.APIPassphrase != "" && us.APISecret != "",
+		HasPassphrase: us.APIPassphrase != "",
+		HasSecret:     us.APISecret != "",
+	}
+	if len(us.APIKey) > 8 {
+		c.APIKeySuffix = us.APIKey[len(us.APIKey)-4:]
+	}
+	return c
+}
+
+// parseLimit parses the limit query parameter of list endpoints
+func parseLimit(value string) (int, error) {
+	if value == "" {
+		return apiDefaultLimit, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 {
+		return 0, errors.New("invalid limit parameter")
+	}
+	if limit > apiMaxLimit {
+		limit = apiMaxLimit
+	}
+	return limit, nil
+}
+
+// readJSON decodes the json request body into v. If the body is invalid, an error is sent.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		writeAPIError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+		return false
+	}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiMaxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// writeJSON sends v as json response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	logger.LogErrorIfExists(json.NewEncoder(w).Encode(v))
+}
+
+// writeAPIError sends an error message as json response
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
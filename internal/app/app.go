@@ -2,30 +2,35 @@ package app
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/sha256"
 	"encoding/gob"
-	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/NicoNex/echotron/v3"
 	"github.com/foxever/sqlite"
 	"github.com/gorilla/mux"
-	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
+	"github.com/sknr/go-coinbasepro-notifier/internal/audit"
+	"github.com/sknr/go-coinbasepro-notifier/internal/conversation"
 	"github.com/sknr/go-coinbasepro-notifier/internal/database"
 	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"github.com/sknr/go-coinbasepro-notifier/internal/login"
+	"github.com/sknr/go-coinbasepro-notifier/internal/mute"
+	"github.com/sknr/go-coinbasepro-notifier/internal/role"
+	"github.com/sknr/go-coinbasepro-notifier/internal/session"
+	"github.com/sknr/go-coinbasepro-notifier/internal/supervisor"
 	"github.com/sknr/go-coinbasepro-notifier/internal/telegram"
+	"github.com/sknr/go-coinbasepro-notifier/internal/token"
 	"github.com/sknr/go-coinbasepro-notifier/internal/updater"
 	"github.com/sknr/go-coinbasepro-notifier/internal/utils"
-	"github.com/sknr/go-coinbasepro-notifier/internal/watcher"
+	"github.com/sknr/go-coinbasepro-notifier/internal/view"
+	"github.com/sknr/go-coinbasepro-notifier/static"
 	"gorm.io/gorm"
-	"html/template"
+	"io/fs"
 	"net/http"
 	"os"
 	"os/signal"
-	"sort"
-	"strings"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
@@ -35,17 +40,48 @@ const (
 	sessionName      = "coinbasepro-notifier"
 	maxNumberOfUsers = 25 // Maximum number of users supported
 	version          = "v1.0.3"
+	shutdownTimeout  = 30 * time.Second // Deadline for draining watchers and pending notifications
+	conversationTTL  = 15 * time.Minute // Expiry of unfinished bot conversations
+	purgeInterval    = 5 * time.Minute  // Interval of removing expired conversations, mutes and sessions
+
+	// Exit codes of the app
+	exitCodeOK             = 0
+	exitCodeShutdownFailed = 1 // Shutdown did not complete within the deadline
+	exitCodeServerFailed   = 2 // Webhook server could not be started or stopped unexpectedly
 )
 
 var app *App
 
 type App struct {
 	db            *gorm.DB
-	sessionStore  *sessions.CookieStore
+	sessionStore  sessions.Store
 	telegramToken string
-	watchers      map[string]*watcher.CoinbaseProWatcher
+	supervisor    *supervisor.Supervisor
+	conversations *conversation.Store
+	roles         *role.Store
+	audit         *audit.Log
+	tokens        *token.Store
+	login         *login.Verifier
+	static        fs.FS // Templates and assets of the web interface
+	templates     *view.Templates
+	mutes         *mute.Store
 	updater       *updater.Updater
+	startedAt     time.Time
 	mu            sync.Mutex
+
+	// Running bot handlers, which echotron does not keep track of
+	handlers       sync.WaitGroup
+	handlersMu     sync.Mutex
+	handlersClosed bool // No more updates are handled during shutdown
+
+	// Periodic removal of expired entries, which is stopped by closing stopPurge
+	stopPurge chan struct{}
+	purgeDone chan struct{}
+
+	// Running broadcasts, which get aborted by stopBroadcasts
+	broadcasts     sync.WaitGroup
+	broadcastCtx   context.Context
+	stopBroadcasts context.CancelFunc
 }
 
 type TelegramUser struct {
@@ -60,63 +96,93 @@ type TelegramUser struct {
 func New() *App {
 	a := new(App)
 	a.updater = updater.New()
+	a.broadcastCtx, a.stopBroadcasts = context.WithCancel(context.Background())
 
-	authKeyOne := securecookie.GenerateRandomKey(64)
-	encryptionKeyOne := securecookie.GenerateRandomKey(32)
-
-	a.sessionStore = sessions.NewCookieStore(
-		authKeyOne,
-		encryptionKeyOne,
-	)
-
-	a.sessionStore.Options = &sessions.Options{
-		MaxAge:   3600,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
-	}
 	// Register User for session storage
 	gob.Register(TelegramUser{})
 
 	// Set the telegram token
 	utils.CheckEnvVars("TELEGRAM_TOKEN", "DATABASE_FILE")
 	a.telegramToken = os.Getenv("TELEGRAM_TOKEN")
-
-	// Create clients map
-	a.watchers = make(map[string]*watcher.CoinbaseProWatcher)
+	// Logins via the telegram login widget expire after LOGIN_MAX_AGE (default 1h)
+	var loginMaxAge time.Duration
+	if v := os.Getenv("LOGIN_MAX_AGE"); v != "" {
+		var err error
+		loginMaxAge, err = time.ParseDuration(v)
+		logger.LogErrorIfExists(err)
+	}
+	a.login = login.NewVerifier(a.telegramToken, loginMaxAge)
+
+	// Use the embedded templates and assets. In dev mode they are read from disk on every request instead.
+	a.static = static.FS
+	devMode, _ := strconv.ParseBool(os.Getenv("DEV_MODE"))
+	if devMode {
+		logger.LogWarn("DEV_MODE is enabled => templates and assets are read from the static directory")
+		a.static = os.DirFS("static")
+	}
+	templates, err := view.New(a.static, devMode)
+	if err != nil {
+		panic(err)
+	}
+	a.templates = templates
 
 	// Initialize database
-	var err error
 	a.db, err = gorm.Open(sqlite.Open(os.Getenv("DATABASE_FILE")), &gorm.Config{})
 	logger.LogErrorIfExists(err)
 	// Create table if not exists
-	logger.LogErrorIfExists(a.db.AutoMigrate(&database.UserSettings{}))
+	logger.LogErrorIfExists(a.db.AutoMigrate(&database.UserSettings{}, &database.OrderEvent{}, &database.Conversation{}, &database.Mute{}, &database.Broadcast{}, &database.BroadcastDelivery{}, &database.Notification{}, &database.UserRole{}, &database.AuditEvent{}, &database.APIToken{}, &database.Session{}))
+
+	// Create the session store, which is configured via the SESSION_* env vars
+	sessionConfig, err := session.ConfigFromEnv()
+	if err != nil {
+		panic(err)
+	}
+	a.sessionStore = session.NewStore(sessionConfig, a.db)
+
+	// Create the store for multi-step bot conversations
+	a.conversations = conversation.NewStore(a.db, conversationTTL)
+	// Create the store for muted notifications
+	a.mutes = mute.NewStore(a.db)
+	// Remove the entries which have expired while the app was not running
+	a.purgeExpired()
+
+	// Create the role store and route the role based push messages through it
+	a.roles = role.NewStore(a.db, os.Getenv("TELEGRAM_ADMIN_CHAT_ID"))
+	telegram.SetRoleRecipients(a.roles.IDs)
+	// Create the audit trail of admin actions
+	a.audit = audit.NewLog(a.db)
+	if err = audit.SetTrustedProxies(os.Getenv("TRUSTED_PROXIES")); err != nil {
+		panic(err)
+	}
+	// Create the store for personal api tokens
+	a.tokens = token.NewStore(a.db)
+
+	// Create the supervisor which manages the watchers
+	a.supervisor = supervisor.New(a.updater, a.db)
+	a.supervisor.OnPause(func(settings database.UserSettings) {
+		a.audit.Record(audit.SystemActor(), audit.ActionWatcherPaused, settings.TelegramID, settings.PausedReason)
+	})
 
 	app = a
 	return app
 }
 
 // Start main function to start the coinbase notifier server and
-// the websockets connection for the registered clients
-func (a *App) Start() {
+// the websockets connection for the registered clients.
+// It blocks until the app has been shut down and returns the exit code.
+func (a *App) Start() int {
+	a.startedAt = time.Now()
+	a.startPurge()
 	// Start websocket connections for each client
 	a.startWatchers()
 	// Create router and setup routes
 	logger.LogInfo("Starting server at port 8080")
-	a.startServer()
+	return a.startServer()
 }
 
-// startServer creates the necessary routes for the http.server and registers the bot listing for updates on the webhook handler
-func (a *App) startServer() {
-	router := mux.NewRouter()
-	router.HandleFunc("/", a.homeHandler)
-	router.HandleFunc("/form/settings", a.settingsHandler)
-	router.HandleFunc("/form/delete-profile", a.deleteHandler)
-	router.HandleFunc("/login", a.loginHandler)
-	router.HandleFunc("/logout", a.logoutHandler)
-	// Add static file server
-	fileServer := http.FileServer(http.Dir("./static"))
-	router.PathPrefix("/").Handler(http.StripPrefix("/", fileServer))
+// startServer starts the http.server with the routes of newRouter and registers the bot listing for updates on the webhook handler
+func (a *App) startServer() int {
+	router := a.newRouter()
 
 	termChan := make(chan os.Signal, 1) // Channel for terminating the app via os.Interrupt signal
 	signal.Notify(termChan, syscall.SIGINT, syscall.SIGTERM)
@@ -126,39 +192,174 @@ func (a *App) startServer() {
 	server := &http.Server{Addr: ":8080", Handler: router}
 	// Set custom http.Server
 	dsp.SetHTTPServer(server)
+	// Publish the command menus for regular users and the members with an elevated role
+	commands.publishCommands(echotron.NewAPI(a.telegramToken), a.roles.Members(role.Support))
 
+	exitCode := make(chan int, 1)
 	go func() {
 		<-termChan
 		logger.LogInfo("SIGTERM received -> Shutdown process initiated")
-		a.updater.Stop()
-		logger.LogErrorIfExists(server.Shutdown(context.Background()))
+		exitCode <- a.shutdown(server)
 	}()
 
 	logger.LogInfof("Starting telegram bot server at %q", server.Addr)
 	// Start Webserver with provided webhook
-	logger.LogErrorIfExists(dsp.ListenWebhook("https://notifier.bot.apperia.de/webhook"))
+	err := dsp.ListenWebhook("https://notifier.bot.apperia.de/webhook")
+	if !errors.Is(err, http.ErrServerClosed) {
+		logger.LogErrorIfExists(err)
+		signal.Stop(termChan)
+		a.shutdown(server)
+		return exitCodeServerFailed
+	}
+
+	return <-exitCode
 }
 
-// startWatchers creates a websocket connection for each user
-func (a *App) startWatchers() {
-	a.mu.Lock()
-	defer a.mu.Unlock()
-	var userSettings []database.UserSettings
-	a.db.Where("active = ?", true).Find(&userSettings)
-	for _, settings := range userSettings {
-		// Skip subscription if settings are missing
-		if settings.APIKey == "" {
-			continue
+// newRouter registers the routes of the web interface and the api
+func (a *App) newRouter() *mux.Router {
+	// Each route only accepts the registered methods, all others are answered with 405
+	router := mux.NewRouter()
+	router.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
+	router.HandleFunc("/", a.homeHandler).Methods(http.MethodGet)
+	router.HandleFunc("/login", a.loginHandler).Methods(http.MethodGet)
+	router.HandleFunc("/logout", a.logoutHandler).Methods(http.MethodGet)
+	router.HandleFunc("/dashboard", a.dashboardHandler).Methods(http.MethodGet)
+	router.HandleFunc("/dashboard/orders.csv", a.ordersCSVHandler).Methods(http.MethodGet)
+	router.HandleFunc("/dashboard/notifications.csv", a.notificationsCSVHandler).Methods(http.MethodGet)
+	router.HandleFunc("/api/watchers", a.watchersHandler).Methods(http.MethodGet)
+	router.HandleFunc("/api/audit", a.auditHandler).Methods(http.MethodGet)
+	a.registerAPI(router)
+	a.registerAdmin(router)
+
+	// All state-changing forms are protected against cross-site request forgery
+	forms := router.PathPrefix("/form").Subrouter()
+	forms.Use(a.csrfProtect)
+	forms.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowedHandler)
+	forms.HandleFunc("/settings", a.settingsHandler).Methods(http.MethodPost)
+	forms.HandleFunc("/delete-profile", a.confirmDeleteHandler).Methods(http.MethodGet)
+	forms.HandleFunc("/delete-profile", a.deleteHandler).Methods(http.MethodPost)
+	forms.HandleFunc("/tokens", a.createTokenHandler).Methods(http.MethodPost)
+	forms.HandleFunc("/tokens/revoke", a.revokeTokenHandler).Methods(http.MethodPost)
+
+	// Add static file server for the assets, the templates are only served rendered
+	fileServer := http.FileServer(http.FS(a.static))
+	router.PathPrefix("/assets/").Handler(fileServer).Methods(http.MethodGet, http.MethodHead)
+
+	return router
+
+}
+
+// shutdown stops the app within the shutdownTimeout in the following order:
+// stop accepting webhooks and requests, wait for running bot handlers and broadcasts, stop the watchers,
+// flush their notification queues and close the database.
+// It returns exitCodeShutdownFailed if one of the steps did not complete in time.
+func (a *App) shutdown(server *http.Server) int {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	exitCode := exitCodeOK
+	if err := server.Shutdown(ctx); err != nil {
+		logger.LogError(err)
+		exitCode = exitCodeShutdownFailed
+	}
+	a.updater.Stop()
+
+	logger.LogInfo("Waiting for running bot handlers")
+	if !a.waitForHandlers(ctx) {
+		logger.LogWarn("Bot handlers did not finish in time")
+		exitCode = exitCodeShutdownFailed
+	}
+
+	logger.LogInfo("Waiting for running broadcasts")
+	a.waitForBroadcasts(ctx)
+
+	logger.LogInfo("Stopping watchers and flushing pending notifications")
+	if err := a.supervisor.Shutdown(ctx); err != nil {
+		logger.LogError(err)
+		exitCode = exitCodeShutdownFailed
+	}
+
+	close(a.stopPurge)
+	<-a.purgeDone
+	logger.LogInfo("Closing database")
+	if sqlDB, err := a.db.DB(); err == nil {
+		logger.LogErrorIfExists(sqlDB.Close())
+	}
+
+	logger.LogInfof("Shutdown completed with exit code %d", exitCode)
+	return exitCode
+}
+
+// startPurge removes expired conversations, mutes and sessions every purgeInterval until stopPurge is closed
+func (a *App) startPurge() {
+	a.stopPurge = make(chan struct{})
+	a.purgeDone = make(chan struct{})
+	go func() {
+		defer close(a.purgeDone)
+		ticker := time.NewTicker(purgeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-a.stopPurge:
+				return
+			case <-ticker.C:
+				a.purgeExpired()
+			}
 		}
-		// Create the client
-		a.watchers[settings.TelegramID] = watcher.New(settings, a.updater)
-		// Start watching for user related order updates
-		go a.watchers[settings.TelegramID].Start()
-		// We need to sleep in order to not hit the coinbase pro api limits
-		time.Sleep(1 * time.Second)
+	}()
+}
+
+// purgeExpired removes expired conversations, mutes and sessions
+func (a *App) purgeExpired() {
+	a.conversations.DeleteExpired()
+	a.mutes.DeleteExpired()
+	if store, ok := a.sessionStore.(*session.DatabaseStore); ok {
+		store.DeleteExpired()
+	}
+}
+
+// beginHandler registers a running bot handler, which must call a.handlers.Done when finished.
+// It returns false once the app is shutting down.
+func (a *App) beginHandler() bool {
+	a.handlersMu.Lock()
+	defer a.handlersMu.Unlock()
+	if a.handlersClosed {
+		return false
+	}
+	a.handlers.Add(1)
+	return true
+}
+
+// waitForHandlers stops handling further bot updates and waits until the running handlers have finished.
+// It returns false if ctx is done before.
+func (a *App) waitForHandlers(ctx context.Context) bool {
+	a.handlersMu.Lock()
+	a.handlersClosed = true
+	a.handlersMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		a.handlers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
 	}
 }
 
+// startWatchers creates a websocket connection for each active user without blocking.
+// The supervisor's limiter takes care of not hitting the coinbase pro api limits.
+func (a *App) startWatchers() {
+	var userSettings []database.UserSettings
+	a.db.Where("active = ? AND api_key <> ?", true, "").Find(&userSettings)
+	logger.LogInfof("Scheduling %d watchers", len(userSettings))
+	a.supervisor.StartAll(userSettings)
+}
+
 /************/
 /* Database */
 /************/
@@ -180,6 +381,27 @@ func (a *App) createOrUpdateUser(user TelegramUser) {
 	a.db.Save(&settings)
 }
 
+// updateAPISettings stores the coinbase pro api credentials of the given user and restarts the watcher.
+// A watcher, which has been paused due to invalid credentials, is resumed.
+func (a *App) updateAPISettings(actor audit.Actor, userSettings database.UserSettings, key, passphrase, secret string) database.UserSettings {
+	userSettings.APIKey = key
+	userSettings.APIPassphrase = passphrase
+	userSettings.APISecret = secret
+	userSettings.PausedReason = ""
+	a.db.Save(&userSettings)
+	a.audit.Record(actor, audit.ActionCredentialsChanged, userSettings.TelegramID, "")
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	// Only start a new watcher if user is active. Otherwise, close the existing one.
+	if userSettings.Active {
+		a.supervisor.Start(userSettings)
+	} else {
+		a.supervisor.Stop(userSettings.TelegramID)
+	}
+	return userSettings
+}
+
 // getTotalNumberOfActiveUsers get all active users
 func (a *App) getTotalNumberOfActiveUsers() int {
 	var number int
@@ -204,56 +426,62 @@ func (a *App) getAllUserSettings() []database.UserSettings {
 	return userSettings
 }
 
+// searchUsers returns a page of users whose ID or name contains the given query, together with the total number of matches
+func (a *App) searchUsers(query string, offset, limit int) ([]database.UserSettings, int64) {
+	var (
+		userSettings []database.UserSettings
+		total        int64
+	)
+	db := a.db.Model(&database.UserSettings{})
+	if query != "" {
+		like := "%" + query + "%"
+		db = db.Where("telegram_id LIKE ? OR first_name LIKE ? OR last_name LIKE ? OR username LIKE ?", like, like, like, like)
+	}
+	db.Count(&total)
+	db.Order("first_name, telegram_id").Offset(offset).Limit(limit).Find(&userSettings)
+
+	return userSettings, total
+}
+
+// getLastOrderEventTime returns the time of the last stored order event of a user
+func (a *App) getLastOrderEventTime(telegramID string) time.Time {
+	var event database.OrderEvent
+	a.db.Where("telegram_id = ?", telegramID).Order("time DESC").Limit(1).Find(&event)
+
+	return event.Time
+}
+
 /************/
 /* Handlers */
 /************/
 
 // loginHandler handles the login via telegram login widget
 func (a *App) loginHandler(w http.ResponseWriter, r *http.Request) {
-	submittedHash := r.URL.Query().Get("hash")
-	params := getQueryParams(r, []string{"auth_date", "first_name", "last_name", "photo_url", "id", "username"})
-	var sortedParams []string
-	for key, val := range params {
-		if val != "" {
-			sortedParams = append(sortedParams, key+"="+val)
-		}
-	}
-	sort.Strings(sortedParams)
-	checkString := strings.Join(sortedParams, "\n")
-
-	// Hash the secret
-	hs := sha256.New()
-	hs.Write([]byte(a.telegramToken))
-	// Hash the checkString with the hashed secret
-	h := hmac.New(sha256.New, hs.Sum(nil))
-	h.Write([]byte(checkString))
-	sha := hex.EncodeToString(h.Sum(nil))
-
-	logger.LogDebugf("Raw-Query: %s", r.URL.RawQuery)
-	logger.LogDebugf("QueryParams: %#v", params)
-	logger.LogDebugf("SortedParams: %#v", sortedParams)
-	logger.LogDebugf("Check-String: %s", checkString)
-	logger.LogDebugf("Checksum SHA <> submitted SHA => %s <> %s", sha, submittedHash)
-
-	if sha != submittedHash {
-		logger.LogInfo("Login failed!", params["id"])
-		renderTemplate(w, "error", struct{ ErrorMessage string }{"Checksum-Error! Someone seems to try nasty stuff..."})
+	verified, err := a.login.Verify(r.URL.Query())
+	if err != nil {
+		id := r.URL.Query().Get("id")
+		logger.LogWarnf("[%s] Login failed: %v", id, err)
+		// The ID has not been verified, so it must not be recorded as actor or target
+		a.audit.Record(audit.WebActor(audit.Anonymous, r), audit.ActionLoginFailed, "", fmt.Sprintf("Claimed ID %q: %v", id, err))
+		w.WriteHeader(http.StatusUnauthorized)
+		renderTemplate(w, "error", struct{ ErrorMessage string }{"Login failed: " + err.Error()})
 		return
 	}
 
 	// Login successful
 	session, _ := a.sessionStore.Get(r, sessionName)
 	user := getUser(session)
-	user.ID = params["id"]
-	user.FirstName = params["first_name"]
-	user.LastName = params["last_name"]
-	user.Alias = params["username"]
-	user.PhotoURL = params["photo_url"]
+	user.ID = verified.ID
+	user.FirstName = verified.FirstName
+	user.LastName = verified.LastName
+	user.Alias = verified.Username
+	user.PhotoURL = verified.PhotoURL
 	user.IsAuthenticated = true
 	session.Values["user"] = user
 	logger.LogErrorIfExists(session.Save(r, w))
 
 	a.createOrUpdateUser(user)
+	a.audit.Record(audit.WebActor(user.ID, r), audit.ActionLogin, user.ID, "")
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
@@ -261,6 +489,9 @@ func (a *App) loginHandler(w http.ResponseWriter, r *http.Request) {
 func (a *App) logoutHandler(w http.ResponseWriter, r *http.Request) {
 	// Remove the session
 	session, _ := a.sessionStore.Get(r, sessionName)
+	if user := getUser(session); user.IsAuthenticated {
+		a.audit.Record(audit.WebActor(user.ID, r), audit.ActionLogout, user.ID, "")
+	}
 	session.Options.MaxAge = -1
 	_ = session.Save(r, w)
 	http.Redirect(w, r, "/", http.StatusSeeOther)
@@ -283,22 +514,11 @@ func (a *App) homeHandler(w http.ResponseWriter, r *http.Request) {
 		renderTemplate(w, "index", nil)
 		return
 	}
-	renderTemplate(w, "profile", userSettings)
+	renderTemplate(w, "profile", a.newProfilePage(w, r, userSettings))
 }
 
 // settingsHandler receives the html form post values and updates the user settings
 func (a *App) settingsHandler(w http.ResponseWriter, r *http.Request) {
-	if err := r.ParseForm(); err != nil {
-		logger.LogError(err)
-		renderTemplate(w, "error", struct{ ErrorMessage string }{"Could not parse form"})
-		return
-	}
-
-	if r.Method != http.MethodPost {
-		renderTemplate(w, "error", struct{ ErrorMessage string }{"Method not allowed"})
-		return
-	}
-
 	session, _ := a.sessionStore.Get(r, sessionName)
 	user := getUser(session)
 	if !user.IsAuthenticated {
@@ -308,24 +528,22 @@ func (a *App) settingsHandler(w http.ResponseWriter, r *http.Request) {
 
 	var userSettings = database.UserSettings{}
 	a.db.First(&userSettings, user.ID)
-	userSettings.APIKey = r.FormValue("key")
-	userSettings.APIPassphrase = r.FormValue("passphrase")
-	userSettings.APISecret = r.FormValue("secret")
-	a.db.Save(&userSettings)
+	a.updateAPISettings(audit.WebActor(user.ID, r), userSettings, r.PostFormValue("key"), r.PostFormValue("passphrase"), r.PostFormValue("secret"))
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
 
-	a.mu.Lock()
-	defer a.mu.Unlock()
-	if a.watchers[user.ID] != nil {
-		// Close the existing client
-		a.watchers[user.ID].Stop()
-	}
-	// Only start a new watcher if user is active.
-	if userSettings.Active {
-		a.watchers[user.ID] = watcher.New(userSettings, a.updater)
-		// Start watching for user related order updates
-		go a.watchers[user.ID].Start()
+// confirmDeleteHandler asks the user to confirm the deletion of the profile
+func (a *App) confirmDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := a.sessionStore.Get(r, sessionName)
+	user := getUser(session)
+	if !user.IsAuthenticated {
+		renderTemplate(w, "error", struct{ ErrorMessage string }{"Access denied"})
+		return
 	}
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	renderTemplate(w, "delete-profile", struct {
+		TelegramUser
+		CSRFToken string
+	}{user, a.csrfToken(w, r)})
 }
 
 // deleteHandler removes the user from database and performs logout
@@ -336,94 +554,158 @@ func (a *App) deleteHandler(w http.ResponseWriter, r *http.Request) {
 		renderTemplate(w, "error", struct{ ErrorMessage string }{"Access denied"})
 		return
 	}
+	a.deleteProfile(audit.WebActor(user.ID, r), user)
+
+	// Call logout handler to remove session and redirect user to login page
+	a.logoutHandler(w, r)
+}
+
+// deleteProfile stops the watcher of the user and removes him/her from the database
+func (a *App) deleteProfile(actor audit.Actor, user TelegramUser) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	if a.watchers[user.ID] != nil {
-		// Close the existing client
-		a.watchers[user.ID].Stop()
-		delete(a.watchers, user.ID)
-	}
+	a.supervisor.Stop(user.ID)
 	a.db.Delete(&database.UserSettings{}, user.ID)
+	a.tokens.RevokeAll(user.ID)
 	telegram.SendAdminPushMessage(fmt.Sprintf("User with ID (%s) has deleted his/her profile:\n%#v", user.ID, user))
 	logger.LogInfof("User with ID (%s) has deleted his/her profile:\n%#v", user.ID, user)
+	a.audit.Record(actor, audit.ActionProfileDeleted, user.ID, "")
+}
 
-	// Call logout handler to remove session and redirect user to login page
-	a.logoutHandler(w, r)
+// watchersHandler returns the status of all watchers as json (support role required)
+func (a *App) watchersHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.requireRole(w, r, role.Support); !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	logger.LogErrorIfExists(json.NewEncoder(w).Encode(a.supervisor.Statuses()))
+}
+
+// auditHandler returns the audit events as json (admin role required).
+// The events can be filtered by the query parameters user, action, since (RFC 3339) and limit.
+func (a *App) auditHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.requireRole(w, r, role.Admin); !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	filter := audit.Filter{UserID: query.Get("user"), Action: query.Get("action")}
+	if since := query.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			http.Error(w, "Invalid since parameter, expected RFC 3339", http.StatusBadRequest)
+			return
+		}
+		filter.Since = t
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		filter.Limit = n
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	logger.LogErrorIfExists(json.NewEncoder(w).Encode(a.audit.Query(filter)))
 }
 
-// enableUser sets the active flag to true and starts the watcher
-func (a *App) enableUser(telegramID string) {
+// requireRole checks that the session user has at least the given role. Otherwise, an error is sent.
+func (a *App) requireRole(w http.ResponseWriter, r *http.Request, min role.Role) (TelegramUser, bool) {
+	session, _ := a.sessionStore.Get(r, sessionName)
+	user := getUser(session)
+	if !user.IsAuthenticated {
+		http.Error(w, "Access denied", http.StatusUnauthorized)
+		return user, false
+	}
+	if !a.roles.Get(user.ID).AtLeast(min) {
+		logger.LogWarnf("[%s] User without role %q tries to access %s", user.ID, min, r.URL.Path)
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return user, false
+	}
+	return user, true
+}
+
+// mayManage returns true if the actor's role is higher than the role of the target user.
+// Nobody may manage users of the same or a higher role, including him/herself.
+func (a *App) mayManage(actorID, targetID string) bool {
+	return a.roles.Get(actorID).Outranks(a.roles.Get(targetID))
+}
+
+// enableUser sets the active flag to true and starts the watcher. It returns false if the user does not exist.
+func (a *App) enableUser(telegramID string) bool {
 	var userSettings database.UserSettings
 	err := a.db.Where("telegram_id = ?", telegramID).First(&userSettings).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return
+		return false
 	}
 	userSettings.Active = true
 	a.db.Save(&userSettings)
 
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	if a.watchers[telegramID] != nil {
-		// Close the existing client
-		a.watchers[telegramID].Stop()
-	}
-	a.watchers[telegramID] = watcher.New(userSettings, a.updater)
-	// Start watching for user related order updates
-	go a.watchers[telegramID].Start()
+	a.supervisor.Start(userSettings)
+	return true
 }
 
-// disableUser sets the active flag to false and stops the watcher
-func (a *App) disableUser(telegramID string) {
+// disableUser sets the active flag to false and stops the watcher. It returns false if the user does not exist.
+func (a *App) disableUser(telegramID string) bool {
 	var userSettings database.UserSettings
 	err := a.db.Where("telegram_id = ?", telegramID).First(&userSettings).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return
+		return false
 	}
 	userSettings.Active = false
 	a.db.Save(&userSettings)
 
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	if a.watchers[telegramID] != nil {
-		// Close the existing client
-		a.watchers[telegramID].Stop()
-		delete(a.watchers, telegramID)
-	}
+	a.supervisor.Stop(telegramID)
+	return true
 }
 
-// deleteUser deletes an user from database
-func (a *App) deleteUser(telegramID string) {
+// deleteUser deletes an user from database. It returns false if the user does not exist.
+func (a *App) deleteUser(telegramID string) bool {
 	var userSettings database.UserSettings
 	err := a.db.Where("telegram_id = ?", telegramID).First(&userSettings).Error
 	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return
+		return false
 	}
 
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	if a.watchers[telegramID] != nil {
-		// Close the existing client
-		a.watchers[telegramID].Stop()
-		delete(a.watchers, telegramID)
-	}
+	a.supervisor.Stop(telegramID)
 	a.db.Delete(&userSettings)
+	logger.LogErrorIfExists(a.roles.Set(telegramID, role.User, ""), telegramID)
+	a.tokens.RevokeAll(telegramID)
 	logger.LogInfof("User with ID (%s) has been deleted:\n%#v", telegramID, userSettings)
+	return true
 }
 
-// getQueryParams retrieves the given parameter list from the query
-func getQueryParams(r *http.Request, keys []string) map[string]string {
-	var sortedParams = make(map[string]string)
-	for _, key := range keys {
-		val := r.URL.Query().Get(key)
-		sortedParams[key] = val
+// restartWatcher restarts the watcher of an active user with the current settings from the database.
+// It returns false if the user does not exist or is not active.
+func (a *App) restartWatcher(telegramID string) bool {
+	var userSettings database.UserSettings
+	err := a.db.Where("telegram_id = ?", telegramID).First(&userSettings).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || !userSettings.Active {
+		return false
 	}
 
-	return sortedParams
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.supervisor.Start(userSettings)
+	logger.LogInfof("Watcher of user with ID (%s) has been restarted", telegramID)
+	return true
 }
 
+// renderTemplate renders the page with the given name or responds with an internal server error
 func renderTemplate(w http.ResponseWriter, tmpl string, data interface{}) {
-	t, _ := template.ParseFiles("static/" + tmpl + ".html")
-	logger.LogErrorIfExists(t.Execute(w, data))
+	if err := app.templates.Render(w, tmpl, data); err != nil {
+		logger.LogError(err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
 }
 
 // getUser returns a user from session s. on error returns an empty user
@@ -0,0 +1,48 @@
+package app
+
+import (
+	"fmt"
+	"github.com/sknr/go-coinbasepro-notifier/internal/audit"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auditPageSize is the number of audit events shown by /audit
+const auditPageSize = 20
+
+// handleAudit shows the latest audit events, optionally filtered by a telegram ID or an action (prefix)
+func (b *bot) handleAudit(req request) bool {
+	filter := audit.Filter{Limit: auditPageSize}
+	if len(req.args) > 0 {
+		if _, err := strconv.ParseInt(req.args[0], 10, 64); err == nil {
+			filter.UserID = req.args[0]
+		} else {
+			filter.Action = strings.ToLower(req.args[0])
+		}
+	}
+	b.reply(formatAuditEvents(app.audit.Query(filter)))
+	return false
+}
+
+// formatAuditEvents creates a human-readable list of audit events
+func formatAuditEvents(events []database.AuditEvent) string {
+	if len(events) == 0 {
+		return "No audit events found."
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Latest %d audit events:\n", len(events)))
+	for _, e := range events {
+		sb.WriteString(fmt.Sprintf("\n%s %s\n", e.CreatedAt.Format(time.RFC822), e.Action))
+		sb.WriteString(fmt.Sprintf("  by %s via %s (%s)", e.ActorID, e.Source, e.Address))
+		if e.TargetID != "" && e.TargetID != e.ActorID {
+			sb.WriteString(fmt.Sprintf(" on %s", e.TargetID))
+		}
+		sb.WriteString("\n")
+		if e.Details != "" {
+			sb.WriteString(fmt.Sprintf("  %s\n", e.Details))
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+	"fmt"
+	"github.com/sknr/go-coinbasepro-notifier/internal/coinbase"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"strings"
+)
+
+// defaultQuoteCurrency is used by /balance if no quote currency is given
+const defaultQuoteCurrency = "EUR"
+
+func (b *bot) handleBalance(req request) bool {
+	quote := defaultQuoteCurrency
+	if len(req.args) > 0 {
+		quote = strings.ToUpper(req.args[0])
+	}
+
+	telegramID := b.telegramID()
+	var userSettings database.UserSettings
+	app.db.Where("telegram_id = ?", telegramID).Limit(1).Find(&userSettings)
+	if userSettings.APIKey == "" {
+		b.reply("Your Coinbase Pro API-Settings are missing. Send /start to complete the setup.")
+		return false
+	}
+
+	portfolio, err := coinbase.GetPortfolio(coinbase.NewClient(userSettings), quote, app.updater.GetProductIDs())
+	if err != nil {
+		logger.LogWarnf("[%s] Could not fetch balances from coinbase pro: %v", telegramID, err)
+		b.reply("Your balances could not be fetched from Coinbase Pro. Please try again later.")
+		return false
+	}
+	b.reply(formatPortfolio(portfolio))
+	return false
+}
+
+// formatPortfolio renders the balances and their values in the quote currency
+func formatPortfolio(p coinbase.Portfolio) string {
+	if len(p.Assets) == 0 {
+		return "You have no balances."
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Portfolio valued in %s\n\n", p.Quote))
+	for _, a := range p.Assets {
+		if !a.Valued {
+			sb.WriteString(fmt.Sprintf("• %s %s (no %s price available)\n", a.Balance.String(), a.Currency, p.Quote))
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("• %s %s = %s %s (%s%%)\n", a.Balance.String(), a.Currency, a.Value.StringFixed(2), p.Quote, a.Share.StringFixed(1)))
+	}
+	sb.WriteString(fmt.Sprintf("\nTotal: %s %s", p.Total.StringFixed(2), p.Quote))
+	return sb.String()
+}
@@ -3,153 +3,323 @@ package app
 import (
 	"fmt"
 	"github.com/NicoNex/echotron/v3"
+	"github.com/sknr/go-coinbasepro-notifier/internal/audit"
+	"github.com/sknr/go-coinbasepro-notifier/internal/callback"
+	"github.com/sknr/go-coinbasepro-notifier/internal/conversation"
 	"github.com/sknr/go-coinbasepro-notifier/internal/database"
 	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"github.com/sknr/go-coinbasepro-notifier/internal/role"
+	"github.com/sknr/go-coinbasepro-notifier/internal/supervisor"
 	"github.com/sknr/go-coinbasepro-notifier/internal/telegram"
+	"github.com/sknr/go-coinbasepro-notifier/internal/watcher"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type bot struct {
-	chatID      int64
-	lastCommand string
+	chatID int64
 	echotron.API
 }
 
 const (
 	cmdStart       = "/start"
+	cmdHelp        = "/help"
+	cmdSetup       = "/setup"
+	cmdCancel      = "/cancel"
 	cmdShowVersion = "/version"
+	cmdStatus      = "/status"
+	cmdOrders      = "/orders"
+	cmdBalance     = "/balance"
+	cmdMute        = "/mute"
+	cmdUnmute      = "/unmute"
 	cmdEnableUser  = "/enable_user"
 	cmdDisableUser = "/disable_user"
 	cmdDeleteUser  = "/delete_user"
+	cmdWatchers    = "/watchers"
+	cmdUsers       = "/users"
+	cmdBroadcast   = "/broadcast"
+	cmdStats       = "/stats"
+	cmdRoles       = "/roles"
+	cmdGrant       = "/grant"
+	cmdRevoke      = "/revoke"
+	cmdAudit       = "/audit"
+)
+
+// Actions of the inline buttons, which are encoded within the callback data
+const (
+	actionEnableUser  = "enable"
+	actionDisableUser = "disable"
+	actionDeleteUser  = "delete"
+	actionOrdersPage  = "orders"
+	actionUsers       = "users"
+	actionBroadcast   = "broadcast"
 )
 
 func newBot(chatID int64) echotron.Bot {
 	return &bot{
-		chatID:      chatID,
-		lastCommand: "",
-		API:         echotron.NewAPI(os.Getenv("TELEGRAM_TOKEN")),
+		chatID: chatID,
+		API:    echotron.NewAPI(os.Getenv("TELEGRAM_TOKEN")),
 	}
 }
 
 func (b *bot) Update(update *echotron.Update) {
+	// echotron handles each update within its own goroutine, which has to finish before the database gets closed
+	if !app.beginHandler() {
+		logger.LogWarnf("[%d] Update ignored during shutdown", b.chatID)
+		return
+	}
+	defer app.handlers.Done()
+
 	if update.Message != nil {
 		b.handleMessage(update.Message)
 	}
 	if update.CallbackQuery != nil {
-		b.handleMessage(update.CallbackQuery)
+		b.handleCallback(update.CallbackQuery)
 	}
 }
 
-func (b *bot) handleCommand(msg *echotron.Message, data string) {
-	if isCommand(msg) {
-		logger.LogInfof("[%s:%d] New Command: %s", msg.Chat.FirstName, msg.Chat.ID, msg.Text)
-		b.lastCommand = msg.Text
-		b.handleMessageData(msg, data)
+// handleMessage dispatches commands and follow-up messages of a running conversation
+func (b *bot) handleMessage(msg *echotron.Message) {
+	state, inConversation := app.conversations.Get(b.telegramID())
+	// Photos, stickers and the like carry no text, which the conversation steps would take for an empty input
+	if inConversation && msg.Text == "" {
+		b.reply(fmt.Sprintf("Please send a text message.\nSend %s to abort.", cmdCancel))
 		return
 	}
-	if b.lastCommand != "" && msg != nil {
-		logger.LogInfof("[%s:%d] LastCommand: %s | Data: %s", msg.Chat.FirstName, msg.Chat.ID, b.lastCommand, data)
-		b.handleMessageData(msg, data)
+	// Credentials may start with a slash as well, so the /setup conversation receives everything except /cancel.
+	// Its messages are never logged.
+	if inConversation && state.Command == cmdSetup && !isCancel(msg) {
+		logger.LogInfof("[%s:%d] Conversation: %s | Step: %d", msg.Chat.FirstName, msg.Chat.ID, state.Command, state.Step)
+		b.run(state.Command, request{msg: msg, input: msg.Text, state: &state})
 		return
 	}
-	if msg != nil {
+
+	if isCommand(msg) {
+		logger.LogInfof("[%s:%d] New Command: %s", msg.Chat.FirstName, msg.Chat.ID, msg.Text)
+		name, argText := parseCommand(msg.Text)
+		// A new command always ends the current conversation
+		app.conversations.Delete(b.telegramID())
+		b.run(name, request{msg: msg, argText: argText, state: &conversation.State{}})
+		return
+	}
+
+	if !inConversation {
 		logger.LogInfof("[%s:%d] Message: %s", msg.Chat.FirstName, msg.Chat.ID, msg.Text)
+		return
 	}
+	logger.LogInfof("[%s:%d] Conversation: %s | Step: %d", msg.Chat.FirstName, msg.Chat.ID, state.Command, state.Step)
+	b.run(state.Command, request{msg: msg, input: msg.Text, state: &state})
 }
 
-func (b *bot) handleMessage(msg interface{}) {
-	switch t := msg.(type) {
-	case *echotron.Message:
-		logger.LogInfof("Message received")
-		b.handleCommand(t, "")
-	case *echotron.CallbackQuery:
-		logger.LogInfof("Callback message received! Data: %s", t.Data)
-		b.handleCommand(t.Message, t.Data)
-	default:
-		logger.LogInfof("Unknown message type: %t", t)
+// handleCallback dispatches a pressed inline button according to the action encoded within the callback data
+func (b *bot) handleCallback(cq *echotron.CallbackQuery) {
+	logger.LogInfof("Callback message received! Data: %s", cq.Data)
+	_, err := b.AnswerCallbackQuery(cq.ID, nil)
+	logger.LogErrorIfExists(err, b.chatID)
+
+	action, payload, err := callback.Decode(b.chatID, cq.Data)
+	if err != nil {
+		logger.LogWarnf("[%d] Rejected callback data %q: %v", b.chatID, cq.Data, err)
+		return
 	}
+	cmd, ok := commands.lookupAction(action)
+	if !ok || cq.Message == nil {
+		logger.LogWarnf("[%d] Unknown callback action: %s", b.chatID, action)
+		return
+	}
+	b.run(cmd.name, request{msg: cq.Message, data: payload})
 }
 
-func (b *bot) handleMessageData(msg *echotron.Message, data string) {
-	var err error
-	switch b.lastCommand {
-	case cmdStart:
-		b.sendWelcomeMessage(msg)
-	case cmdEnableUser:
-		if !isAdmin(b.chatID) {
-			logger.LogWarnf("[%s:%d] Non admin user tries to run command: %s", msg.Chat.FirstName, msg.Chat.ID, b.lastCommand)
-			telegram.SendAdminPushMessage(fmt.Sprintf("[%s:%d] Non admin users tries to run command: %s", msg.Chat.FirstName, msg.Chat.ID, b.lastCommand))
-			break
-		}
-		if data == "" {
-			us := app.getUserSettings(false)
-			_, err = b.SendMessage("Enable user: "+data, b.chatID, &echotron.MessageOptions{
-				ReplyMarkup: createInlineButtons(us),
-			})
-			logger.LogErrorIfExists(err, b.chatID)
-			return
-		} else {
-			_, err = b.DeleteMessage(b.chatID, msg.ID)
-			logger.LogErrorIfExists(err, b.chatID)
-			app.enableUser(data)
-		}
-	case cmdDisableUser:
-		if !isAdmin(b.chatID) {
-			logger.LogWarnf("[%s:%d] Non admin users tries to run command: %s", msg.Chat.FirstName, msg.Chat.ID, b.lastCommand)
-			telegram.SendAdminPushMessage(fmt.Sprintf("[%s:%d] Non admin users tries to run command: %s", msg.Chat.FirstName, msg.Chat.ID, b.lastCommand))
-			break
-		}
-		if data == "" {
-			us := app.getUserSettings(true)
-			_, err = b.SendMessage("Disable user: "+data, b.chatID, &echotron.MessageOptions{
-				ReplyMarkup: createInlineButtons(us),
-			})
-			logger.LogErrorIfExists(err, b.chatID)
-			return
-		} else {
-			_, err = b.DeleteMessage(b.chatID, msg.ID)
-			logger.LogErrorIfExists(err, b.chatID)
-			app.disableUser(data)
-		}
-	case cmdDeleteUser:
-		if !isAdmin(b.chatID) {
-			logger.LogWarnf("[%s:%d] Non admin users tries to run command: %s", msg.Chat.FirstName, msg.Chat.ID, b.lastCommand)
-			telegram.SendAdminPushMessage(fmt.Sprintf("[%s:%d] Non admin users tries to run command: %s", msg.Chat.FirstName, msg.Chat.ID, b.lastCommand))
-			break
-		}
-		if data == "" {
-			us := app.getAllUserSettings()
-			_, err = b.SendMessage("Delete user: "+data, b.chatID, &echotron.MessageOptions{
-				ReplyMarkup: createInlineButtons(us),
-			})
-			logger.LogErrorIfExists(err, b.chatID)
+// run executes the given command. The conversation state is only updated for messages, not for callbacks.
+func (b *bot) run(name string, req request) {
+	msg := req.msg
+	cmd, ok := commands.lookup(name)
+	if !ok {
+		logger.LogInfof("[%s:%d] Unknown command: %s", msg.Chat.FirstName, msg.Chat.ID, name)
+		b.reply(fmt.Sprintf("Unknown command %s. Send %s to see all available commands.", name, cmdHelp))
+		return
+	}
+	if !b.role().AtLeast(cmd.role) {
+		logger.LogWarnf("[%s:%d] User without role %q tries to run command: %s", msg.Chat.FirstName, msg.Chat.ID, cmd.role, name)
+		telegram.SendAdminPushMessage(fmt.Sprintf("[%s:%d] User without role %q tries to run command: %s", msg.Chat.FirstName, msg.Chat.ID, cmd.role, name))
+		return
+	}
+
+	if req.state == nil {
+		// Callbacks are independent of the conversation
+		cmd.handler(b, req)
+		return
+	}
+
+	if req.input == "" {
+		args, err := cmd.parseArgs(req.argText)
+		if err != nil {
+			b.reply(strings.TrimSpace(fmt.Sprintf("Usage: %s %s", cmd.name, cmd.usage)))
 			return
-		} else {
-			_, err = b.DeleteMessage(b.chatID, msg.ID)
-			logger.LogErrorIfExists(err, b.chatID)
-			app.deleteUser(data)
 		}
-	case cmdShowVersion:
-		_, err = b.SendMessage(version, b.chatID, nil)
+		req.args = args
+	}
+
+	req.state.Command = cmd.name
+	if awaitsInput := cmd.handler(b, req); awaitsInput {
+		app.conversations.Save(b.telegramID(), *req.state)
+		return
+	}
+	app.conversations.Delete(b.telegramID())
+}
+
+// reply sends a text message to the current chat
+func (b *bot) reply(text string) {
+	_, err := b.SendMessage(text, b.chatID, nil)
+	logger.LogErrorIfExists(err, b.chatID)
+}
+
+// telegramID returns the chat ID as used within the database
+func (b *bot) telegramID() string {
+	return strconv.FormatInt(b.chatID, 10)
+}
+
+// actor returns the current chat as actor of the audit trail
+func (b *bot) actor() audit.Actor {
+	return audit.BotActor(b.telegramID())
+}
+
+// role returns the role of the current chat
+func (b *bot) role() role.Role {
+	return app.roles.Get(b.telegramID())
+}
+
+/********************/
+/* Command handlers */
+/********************/
+
+func (b *bot) handleStart(req request) bool {
+	b.sendWelcomeMessage(req.msg)
+	return false
+}
+
+func (b *bot) handleHelp(_ request) bool {
+	b.reply(commands.help(b.role()))
+	return false
+}
+
+func (b *bot) handleCancel(_ request) bool {
+	b.reply("Cancelled.")
+	return false
+}
+
+func (b *bot) handleVersion(_ request) bool {
+	b.reply(version)
+	return false
+}
+
+func (b *bot) handleStatus(_ request) bool {
+	telegramID := b.telegramID()
+	var userSettings database.UserSettings
+	app.db.Where("telegram_id = ?", telegramID).Limit(1).Find(&userSettings)
+	status, running := app.supervisor.Status(telegramID)
+	b.reply(formatUserStatus(userSettings, status, running, len(app.updater.GetProductIDs())))
+	return false
+}
+
+func (b *bot) handleEnableUser(req request) bool {
+	b.selectUser(req, "Enable user: ", actionEnableUser, app.getUserSettings(false), b.enableUser)
+	return false
+}
+
+func (b *bot) handleDisableUser(req request) bool {
+	b.selectUser(req, "Disable user: ", actionDisableUser, app.getUserSettings(true), b.disableUser)
+	return false
+}
+
+func (b *bot) handleDeleteUser(req request) bool {
+	b.selectUser(req, "Delete user: ", actionDeleteUser, app.getAllUserSettings(), b.deleteUser)
+	return false
+}
+
+func (b *bot) handleWatchers(_ request) bool {
+	b.reply(formatWatcherStatuses(app.supervisor.Statuses()))
+	return false
+}
+
+// selectUser shows the given users as inline buttons and calls fn with the telegram ID of the selected user
+func (b *bot) selectUser(req request, text, action string, us []database.UserSettings, fn func(telegramID string)) {
+	if req.data == "" {
+		_, err := b.SendMessage(text, b.chatID, &echotron.MessageOptions{
+			ReplyMarkup: createInlineButtons(b.chatID, action, us),
+		})
 		logger.LogErrorIfExists(err, b.chatID)
-	default:
-		logger.LogInfof("[%s:%d] Unknown command: %s", msg.Chat.FirstName, msg.Chat.ID, b.lastCommand)
+		return
+	}
+	_, err := b.DeleteMessage(b.chatID, req.msg.ID)
+	logger.LogErrorIfExists(err, b.chatID)
+	fn(req.data)
+}
+
+// mayManage checks whether the current chat may manage the given user and informs the chat if not
+func (b *bot) mayManage(telegramID string) bool {
+	if app.mayManage(b.telegramID(), telegramID) {
+		return true
+	}
+	logger.LogWarnf("[%d] Rejected action on user %s with the same or a higher role", b.chatID, telegramID)
+	b.reply(fmt.Sprintf("You are not allowed to manage user %s, because his/her role is not below yours.", telegramID))
+	return false
+}
+
+// enableUser enables the user and records the action of the current chat within the audit trail
+func (b *bot) enableUser(telegramID string) {
+	if !b.mayManage(telegramID) {
+		return
+	}
+	if app.enableUser(telegramID) {
+		app.audit.Record(b.actor(), audit.ActionUserEnabled, telegramID, "")
+	}
+}
+
+// disableUser disables the user and records the action of the current chat within the audit trail
+func (b *bot) disableUser(telegramID string) {
+	if !b.mayManage(telegramID) {
+		return
+	}
+	if app.disableUser(telegramID) {
+		app.audit.Record(b.actor(), audit.ActionUserDisabled, telegramID, "")
 	}
-	b.lastCommand = ""
 }
 
-func isAdmin(id int64) bool {
-	return strconv.FormatInt(id, 10) == os.Getenv("TELEGRAM_ADMIN_CHAT_ID")
+// deleteUser deletes the user and records the action of the current chat within the audit trail
+func (b *bot) deleteUser(telegramID string) {
+	if !b.mayManage(telegramID) {
+		return
+	}
+	if app.deleteUser(telegramID) {
+		app.audit.Record(b.actor(), audit.ActionUserDeleted, telegramID, "")
+	}
+}
+
+// restartWatcher restarts the watcher of the user and records the action of the current chat within the audit trail
+func (b *bot) restartWatcher(telegramID string) {
+	if !b.mayManage(telegramID) {
+		return
+	}
+	if app.restartWatcher(telegramID) {
+		app.audit.Record(b.actor(), audit.ActionWatcherRestarted, telegramID, "")
+	}
 }
 
 func isCommand(message *echotron.Message) bool {
 	return message != nil && strings.HasPrefix(message.Text, "/")
 }
 
+// isCancel returns true if the message is the /cancel command without arguments
+func isCancel(message *echotron.Message) bool {
+	name, args := parseCommand(message.Text)
+	return name == cmdCancel && args == ""
+}
+
 func (b *bot) sendWelcomeMessage(msg *echotron.Message) {
-	_, err := b.SendMessage(fmt.Sprintf("Hi %s,\n🤝 welcome to Coinbase Pro Notifier. Please click the setup button below to complete the setup in order to get informed about your Coinbase Pro order updates", msg.Chat.FirstName), msg.Chat.ID, &echotron.MessageOptions{
+	_, err := b.SendMessage(fmt.Sprintf("Hi %s,\n🤝 welcome to Coinbase Pro Notifier. Please click the setup button below or send %s to complete the setup in order to get informed about your Coinbase Pro order updates", msg.Chat.FirstName, cmdSetup), msg.Chat.ID, &echotron.MessageOptions{
 		ReplyMarkup: echotron.InlineKeyboardMarkup{
 			InlineKeyboard: [][]echotron.InlineKeyboardButton{
 				{
@@ -167,7 +337,8 @@ func (b *bot) sendWelcomeMessage(msg *echotron.Message) {
 	logger.LogErrorIfExists(err, b.chatID)
 }
 
-func createInlineButtons(settings []database.UserSettings) echotron.InlineKeyboardMarkup {
+// createInlineButtons creates two buttons per row for the given users, which trigger the given action
+func createInlineButtons(chatID int64, action string, settings []database.UserSettings) echotron.InlineKeyboardMarkup {
 	var (
 		row     []echotron.InlineKeyboardButton
 		buttons [][]echotron.InlineKeyboardButton
@@ -175,7 +346,7 @@ func createInlineButtons(settings []database.UserSettings) echotron.InlineKeyboa
 	for i, user := range settings {
 		btn := echotron.InlineKeyboardButton{
 			Text:         fmt.Sprintf("%s (%s)", user.FirstName, user.TelegramID),
-			CallbackData: user.TelegramID,
+			CallbackData: callback.Encode(chatID, action, user.TelegramID),
 		}
 		row = append(row, btn)
 		if (i+1)%2 == 0 {
@@ -189,3 +360,66 @@ func createInlineButtons(settings []database.UserSettings) echotron.InlineKeyboa
 		InlineKeyboard: buttons,
 	}
 }
+
+// formatUserStatus creates the /status output for a single user
+func formatUserStatus(us database.UserSettings, s supervisor.WatcherStatus, running bool, products int) string {
+	var sb strings.Builder
+	switch {
+	case us.TelegramID == "":
+		sb.WriteString("You are not registered yet. Send /start to set up your notifications.\n")
+	case us.APIKey == "":
+		sb.WriteString("Your Coinbase Pro API-Settings are missing. Send /start to complete the setup.\n")
+	case !us.Active:
+		sb.WriteString("Your account has not been activated yet.\n")
+	}
+
+	running = running && !s.Paused && s.State != watcher.StateStopped
+	sb.WriteString(fmt.Sprintf("Notifier running: %s\n", yesNo(running)))
+	if s.Paused {
+		sb.WriteString(fmt.Sprintf("Notifier paused: %s\n", s.PauseReason))
+	}
+	if running {
+		sb.WriteString(fmt.Sprintf("Connected: %s\n", yesNo(s.Connected)))
+		sb.WriteString(fmt.Sprintf("Subscribed: %s\n", yesNo(s.State == watcher.StateSubscribed)))
+		sb.WriteString(fmt.Sprintf("State: %s since %s\n", s.State, s.Since.Format(time.RFC822)))
+		sb.WriteString(fmt.Sprintf("Subscribed products: %d\n", products))
+	}
+	if !s.LastMessageAt.IsZero() {
+		sb.WriteString(fmt.Sprintf("Last event: %s\n", s.LastMessageAt.Format(time.RFC822)))
+	}
+	if s.LastError != "" {
+		sb.WriteString(fmt.Sprintf("Last error: %s (%s)\n", s.LastError, s.LastErrorAt.Format(time.RFC822)))
+	}
+	return sb.String()
+}
+
+// yesNo formats a boolean for humans
+func yesNo(b bool) string {
+	if b {
+		return "✅ yes"
+	}
+	return "❌ no"
+}
+
+// formatWatcherStatuses creates a human-readable overview of the given watcher states
+func formatWatcherStatuses(statuses []supervisor.WatcherStatus) string {
+	if len(statuses) == 0 {
+		return "No watchers running"
+	}
+	var sb strings.Builder
+	for _, s := range statuses {
+		state := string(s.State)
+		if s.Paused {
+			state = "paused (" + s.PauseReason + ")"
+		}
+		sb.WriteString(fmt.Sprintf("%s (%s): %s since %s\n", s.FirstName, s.TelegramID, state, s.Since.Format(time.RFC822)))
+		if !s.LastMessageAt.IsZero() {
+			sb.WriteString(fmt.Sprintf("  Last message: %s\n", s.LastMessageAt.Format(time.RFC822)))
+		}
+		sb.WriteString(fmt.Sprintf("  Errors: %d | Reconnects: %d\n", s.ErrorCount, s.Reconnects))
+		if s.LastError != "" {
+			sb.WriteString(fmt.Sprintf("  Last error: %s\n", s.LastError))
+		}
+	}
+	return sb.String()
+}
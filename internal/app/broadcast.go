@@ -0,0 +1,211 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/sknr/go-coinbasepro-notifier/internal/audit"
+	"github.com/sknr/go-coinbasepro-notifier/internal/callback"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"github.com/sknr/go-coinbasepro-notifier/internal/telegram"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Audiences of a broadcast, which are also used as operations of the preview buttons
+const (
+	broadcastAudienceAll    = "all"
+	broadcastAudienceActive = "active"
+	broadcastOpCancel       = "cancel"
+)
+
+// States of a broadcast
+const (
+	broadcastStatusDraft     = "draft"
+	broadcastStatusSending   = "sending"
+	broadcastStatusDone      = "done"
+	broadcastStatusCancelled = "cancelled"
+	broadcastStatusAborted   = "aborted"
+)
+
+// broadcastMaxReportedFailures limits the number of failed recipients listed within the summary
+const broadcastMaxReportedFailures = 10
+
+// handleBroadcast asks for the announcement, shows a preview and sends it after the audience has been confirmed
+func (b *bot) handleBroadcast(req request) bool {
+	if req.data != "" {
+		b.confirmBroadcast(req)
+		return false
+	}
+	if req.input == "" {
+		b.reply(fmt.Sprintf("Please enter the message, which should be sent to the users.\nSend %s to abort.", cmdCancel))
+		return true
+	}
+
+	broadcast := database.Broadcast{CreatedBy: b.telegramID(), Text: req.input, Status: broadcastStatusDraft}
+	app.db.Create(&broadcast)
+
+	all := len(app.getBroadcastRecipients(broadcastAudienceAll))
+	active := len(app.getBroadcastRecipients(broadcastAudienceActive))
+	id := strconv.FormatUint(uint64(broadcast.ID), 10)
+	markup := echotron.InlineKeyboardMarkup{
+		InlineKeyboard: [][]echotron.InlineKeyboardButton{
+			{
+				{Text: fmt.Sprintf("📣 All users (%d)", all), CallbackData: callback.Encode(b.chatID, actionBroadcast, broadcastAudienceAll+":"+id)},
+				{Text: fmt.Sprintf("📣 Active users (%d)", active), CallbackData: callback.Encode(b.chatID, actionBroadcast, broadcastAudienceActive+":"+id)},
+			},
+			{
+				{Text: "Cancel", CallbackData: callback.Encode(b.chatID, actionBroadcast, broadcastOpCancel+":"+id)},
+			},
+		},
+	}
+	text := fmt.Sprintf("Preview of broadcast #%d:\n\n%s\n\nTo whom should the message be sent?", broadcast.ID, broadcast.Text)
+	_, err := b.SendMessage(text, b.chatID, &echotron.MessageOptions{ReplyMarkup: markup})
+	logger.LogErrorIfExists(err, b.chatID)
+	return false
+}
+
+// confirmBroadcast handles the preview buttons. Each draft can only be sent or cancelled once.
+func (b *bot) confirmBroadcast(req request) {
+	// Payload of the preview buttons: <audience or cancel>:<broadcast ID>
+	parts := strings.SplitN(req.data, ":", 2)
+	if len(parts) != 2 {
+		return
+	}
+	op := parts[0]
+	id, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return
+	}
+
+	var text string
+	switch op {
+	case broadcastOpCancel:
+		if !app.claimBroadcast(uint(id), broadcastStatusCancelled, "") {
+			return
+		}
+		text = fmt.Sprintf("Broadcast #%d cancelled.", id)
+	case broadcastAudienceAll, broadcastAudienceActive:
+		if !app.claimBroadcast(uint(id), broadcastStatusSending, op) {
+			return
+		}
+		text = fmt.Sprintf("📣 Sending broadcast #%d to %s users. You will receive a summary when it has been delivered.", id, op)
+		app.audit.Record(b.actor(), audit.ActionBroadcastSent, "", fmt.Sprintf("Broadcast #%d to %s users", id, op))
+		app.startBroadcast(uint(id))
+	default:
+		return
+	}
+
+	_, err = b.EditMessageText(text, echotron.NewMessageID(b.chatID, req.msg.ID), nil)
+	logger.LogErrorIfExists(err, b.chatID)
+}
+
+// claimBroadcast moves a draft into the given status. It returns false if the broadcast is not a draft anymore.
+func (a *App) claimBroadcast(id uint, status, audience string) bool {
+	res := a.db.Model(&database.Broadcast{}).
+		Where("id = ? AND status = ?", id, broadcastStatusDraft).
+		Updates(database.Broadcast{Status: status, Audience: audience})
+	return res.Error == nil && res.RowsAffected == 1
+}
+
+// getBroadcastRecipients returns the telegram IDs of all users of the given audience
+func (a *App) getBroadcastRecipients(audience string) []string {
+	var telegramIDs []string
+	query := a.db.Model(&database.UserSettings{})
+	if audience == broadcastAudienceActive {
+		query = query.Where("active = ?", true)
+	}
+	query.Order("telegram_id").Pluck("telegram_id", &telegramIDs)
+
+	return telegramIDs
+}
+
+// startBroadcast delivers the broadcast in the background
+func (a *App) startBroadcast(id uint) {
+	a.broadcasts.Add(1)
+	go func() {
+		defer a.broadcasts.Done()
+		a.sendBroadcast(id)
+	}()
+}
+
+// sendBroadcast delivers the broadcast through the rate limited telegram delivery,
+// stores the result for each recipient and reports a summary to the admin who created it.
+func (a *App) sendBroadcast(id uint) {
+	var broadcast database.Broadcast
+	if err := a.db.First(&broadcast, id).Error; err != nil {
+		logger.LogError(err)
+		return
+	}
+	recipients := a.getBroadcastRecipients(broadcast.Audience)
+	a.db.Model(&broadcast).Update("recipients", len(recipients))
+	logger.LogInfof("Sending broadcast #%d to %d users", id, len(recipients))
+
+	var failures []database.BroadcastDelivery
+	status := broadcastStatusDone
+	for _, telegramID := range recipients {
+		err := telegram.Send(a.broadcastCtx, telegramID, broadcast.Text, nil)
+		if a.broadcastCtx.Err() != nil {
+			status = broadcastStatusAborted
+			break
+		}
+		delivery := database.BroadcastDelivery{BroadcastID: id, TelegramID: telegramID, Delivered: err == nil}
+		if err != nil {
+			delivery.Error = err.Error()
+			broadcast.Failed++
+			failures = append(failures, delivery)
+			logger.LogWarnf("[%s] Broadcast #%d could not be delivered: %v", telegramID, id, err)
+		} else {
+			broadcast.Delivered++
+		}
+		a.db.Create(&delivery)
+	}
+
+	a.db.Model(&broadcast).Updates(map[string]interface{}{
+		"status":      status,
+		"delivered":   broadcast.Delivered,
+		"failed":      broadcast.Failed,
+		"finished_at": time.Now(),
+	})
+	logger.LogInfof("Broadcast #%d %s: %d delivered, %d failed", id, status, broadcast.Delivered, broadcast.Failed)
+	telegram.SendPushMessage(broadcast.CreatedBy, formatBroadcastSummary(broadcast, status, len(recipients), failures))
+}
+
+// waitForBroadcasts waits until all running broadcasts have been delivered.
+// If ctx is done before, the broadcasts get aborted.
+func (a *App) waitForBroadcasts(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		a.broadcasts.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		a.stopBroadcasts()
+		<-done
+	}
+}
+
+// formatBroadcastSummary creates the delivery report of a broadcast
+func formatBroadcastSummary(broadcast database.Broadcast, status string, recipients int, failures []database.BroadcastDelivery) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("📣 Broadcast #%d %s\n", broadcast.ID, status))
+	sb.WriteString(fmt.Sprintf("Recipients: %d (%s users)\n", recipients, broadcast.Audience))
+	sb.WriteString(fmt.Sprintf("Delivered: %d\n", broadcast.Delivered))
+	sb.WriteString(fmt.Sprintf("Failed: %d\n", broadcast.Failed))
+	if skipped := recipients - broadcast.Delivered - broadcast.Failed; skipped > 0 {
+		sb.WriteString(fmt.Sprintf("Not sent: %d\n", skipped))
+	}
+	for i, f := range failures {
+		if i == broadcastMaxReportedFailures {
+			sb.WriteString(fmt.Sprintf("… and %d more\n", len(failures)-i))
+			break
+		}
+		sb.WriteString(fmt.Sprintf("• %s: %s\n", f.TelegramID, f.Error))
+	}
+	return sb.String()
+}
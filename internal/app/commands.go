@@ -0,0 +1,236 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/sknr/go-coinbasepro-notifier/internal/callback"
+	"github.com/sknr/go-coinbasepro-notifier/internal/conversation"
+	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"github.com/sknr/go-coinbasepro-notifier/internal/role"
+	"strconv"
+	"strings"
+)
+
+// Usage hints of commands with several arguments
+const (
+	muteUsage  = "[product] [duration like 30m, 2h, 1d or 1w, at most one year]"
+	grantUsage = "<telegram ID> <support|admin|owner>"
+)
+
+// errInvalidArgs is returned by an argParser if the given arguments cannot be used for the command
+var errInvalidArgs = errors.New("invalid arguments")
+
+// request holds everything a command handler needs to know about the current invocation
+type request struct {
+	msg     *echotron.Message
+	argText string              // Raw text following the command name
+	args    []string            // Parsed arguments of the command
+	data    string              // Verified payload of a pressed inline button
+	input   string              // Text of a follow-up message sent while the command awaits input
+	state   *conversation.State // Conversation state, which is persisted if the handler awaits further input (nil for callbacks)
+}
+
+// commandHandler handles a command and returns true if the command awaits further input (e.g. a pressed inline button)
+type commandHandler func(b *bot, req request) bool
+
+// argParser parses the text following the command name
+type argParser func(text string) ([]string, error)
+
+// command describes a bot command
+type command struct {
+	name        string    // Name including the leading slash, e.g. "/help"
+	description string    // Description shown in the command menu and the /help output
+	usage       string    // Optional usage hint for the arguments, e.g. "[product]"
+	role        role.Role // Minimum role required to run the command, defaults to role.User
+	action      string    // Callback action of the inline buttons created by the command
+	handler     commandHandler
+	parseArgs   argParser // Defaults to noArgs
+}
+
+// commandRegistry holds all bot commands in the order of their registration
+type commandRegistry struct {
+	commands map[string]*command
+	actions  map[string]*command
+	order    []string
+}
+
+func newCommandRegistry() *commandRegistry {
+	return &commandRegistry{
+		commands: make(map[string]*command),
+		actions:  make(map[string]*command),
+	}
+}
+
+// register adds the given command to the registry
+func (r *commandRegistry) register(c command) {
+	if c.parseArgs == nil {
+		c.parseArgs = noArgs
+	}
+	if c.role == "" {
+		c.role = role.User
+	}
+	if _, ok := r.commands[c.name]; ok {
+		panic(fmt.Sprintf("Command %s registered twice", c.name))
+	}
+	r.commands[c.name] = &c
+	r.order = append(r.order, c.name)
+	if c.action != "" {
+		r.actions[c.action] = &c
+	}
+}
+
+// lookup returns the command with the given name
+func (r *commandRegistry) lookup(name string) (*command, bool) {
+	c, ok := r.commands[name]
+	return c, ok
+}
+
+// lookupAction returns the command which handles the given callback action
+func (r *commandRegistry) lookupAction(action string) (*command, bool) {
+	c, ok := r.actions[action]
+	return c, ok
+}
+
+// available returns all commands, which can be used with the given role
+func (r *commandRegistry) available(userRole role.Role) []*command {
+	var commands []*command
+	for _, name := range r.order {
+		c := r.commands[name]
+		if !userRole.AtLeast(c.role) {
+			continue
+		}
+		commands = append(commands, c)
+	}
+	return commands
+}
+
+// help creates the /help output for the given role, grouped by the role required for the commands
+func (r *commandRegistry) help(userRole role.Role) string {
+	groups := make(map[role.Role][]string)
+	for _, c := range r.available(userRole) {
+		line := c.name
+		if c.usage != "" {
+			line += " " + c.usage
+		}
+		line += " - " + c.description
+		groups[c.role] = append(groups[c.role], line)
+	}
+
+	sb := strings.Builder{}
+	sb.WriteString("Available commands:\n")
+	sb.WriteString(strings.Join(groups[role.User], "\n"))
+	for _, group := range role.All[1:] {
+		if len(groups[group]) > 0 {
+			sb.WriteString(fmt.Sprintf("\n\n%s commands:\n", strings.ToUpper(string(group[:1]))+string(group[1:])))
+			sb.WriteString(strings.Join(groups[group], "\n"))
+		}
+	}
+	return sb.String()
+}
+
+// botCommands converts the commands available for the given role into telegram bot commands
+func (r *commandRegistry) botCommands(userRole role.Role) []echotron.BotCommand {
+	var botCommands []echotron.BotCommand
+	for _, c := range r.available(userRole) {
+		botCommands = append(botCommands, echotron.BotCommand{
+			Command:     strings.TrimPrefix(c.name, "/"),
+			Description: c.description,
+		})
+	}
+	return botCommands
+}
+
+// publishCommands publishes the command menu via setMyCommands.
+// Regular users get the default menu, whereas members with an elevated role get the commands of their role.
+func (r *commandRegistry) publishCommands(api echotron.API, members []role.Member) {
+	_, err := api.SetMyCommands(nil, r.botCommands(role.User)...)
+	logger.LogErrorIfExists(err)
+
+	for _, m := range members {
+		r.publishMemberCommands(api, m.TelegramID, m.Role)
+	}
+}
+
+// publishMemberCommands publishes the command menu of a single chat according to its role
+func (r *commandRegistry) publishMemberCommands(api echotron.API, telegramID string, userRole role.Role) {
+	chatID, err := strconv.ParseInt(telegramID, 10, 64)
+	if err != nil {
+		return
+	}
+	opts := &echotron.CommandOptions{
+		Scope: echotron.BotCommandScope{Type: echotron.BCSTChat, ChatID: chatID},
+	}
+	if userRole == role.User {
+		// Fall back to the default menu
+		_, err = api.DeleteMyCommands(opts)
+	} else {
+		_, err = api.SetMyCommands(opts, r.botCommands(userRole)...)
+	}
+	logger.LogErrorIfExists(err, chatID)
+}
+
+// parseCommand splits the message text into the command name and the remaining argument text.
+// A bot name suffix like in "/help@CoinbaseProNotifierBot" is removed.
+func parseCommand(text string) (name, args string) {
+	text = strings.TrimSpace(text)
+	name = text
+	if i := strings.IndexAny(text, " \n"); i >= 0 {
+		name, args = text[:i], strings.TrimSpace(text[i+1:])
+	}
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+	return strings.ToLower(name), args
+}
+
+// noArgs is the argParser for commands without arguments
+func noArgs(text string) ([]string, error) {
+	if text != "" {
+		return nil, errInvalidArgs
+	}
+	return nil, nil
+}
+
+// optionalArgs returns an argParser which accepts up to max whitespace separated arguments
+func optionalArgs(max int) argParser {
+	return func(text string) ([]string, error) {
+		args := strings.Fields(text)
+		if len(args) > max {
+			return nil, errInvalidArgs
+		}
+		return args, nil
+	}
+}
+
+// anyArgs is the argParser for commands, which use the whole argument text
+func anyArgs(text string) ([]string, error) {
+	return strings.Fields(text), nil
+}
+
+// commands is the registry of all bot commands
+var commands = newCommandRegistry()
+
+func init() {
+	commands.register(command{name: cmdStart, description: "Show the welcome message and the setup link", handler: (*bot).handleStart})
+	commands.register(command{name: cmdHelp, description: "List all available commands", handler: (*bot).handleHelp})
+	commands.register(command{name: cmdStatus, description: "Show the state of your notifications", handler: (*bot).handleStatus})
+	commands.register(command{name: cmdOrders, description: "List your open orders", usage: "[product]", action: actionOrdersPage, handler: (*bot).handleOrders, parseArgs: optionalArgs(1)})
+	commands.register(command{name: cmdBalance, description: "Show your balances and their value", usage: "[quote currency]", handler: (*bot).handleBalance, parseArgs: optionalArgs(1)})
+	commands.register(command{name: cmdMute, description: "Mute notifications temporarily, e.g. /mute BTC-EUR 1d", usage: muteUsage, action: callback.ActionMute, handler: (*bot).handleMute, parseArgs: optionalArgs(2)})
+	commands.register(command{name: cmdUnmute, description: "Unmute notifications", usage: "[product]", handler: (*bot).handleUnmute, parseArgs: optionalArgs(1)})
+	commands.register(command{name: cmdSetup, description: "Set up your Coinbase Pro API-Key step by step", handler: (*bot).handleSetup})
+	commands.register(command{name: cmdCancel, description: "Cancel the current operation", handler: (*bot).handleCancel})
+	commands.register(command{name: cmdShowVersion, description: "Show the version of the bot", handler: (*bot).handleVersion})
+	commands.register(command{name: cmdUsers, description: "Browse and manage the users", usage: "[search]", role: role.Admin, action: actionUsers, handler: (*bot).handleUsers, parseArgs: anyArgs})
+	commands.register(command{name: cmdWatchers, description: "Show the state of all watchers", role: role.Support, handler: (*bot).handleWatchers})
+	commands.register(command{name: cmdStats, description: "Show the metrics of this instance", role: role.Support, handler: (*bot).handleStats})
+	commands.register(command{name: cmdBroadcast, description: "Send an announcement to all users", role: role.Admin, action: actionBroadcast, handler: (*bot).handleBroadcast})
+	commands.register(command{name: cmdEnableUser, description: "Enable a user", role: role.Admin, action: actionEnableUser, handler: (*bot).handleEnableUser})
+	commands.register(command{name: cmdDisableUser, description: "Disable a user", role: role.Admin, action: actionDisableUser, handler: (*bot).handleDisableUser})
+	commands.register(command{name: cmdDeleteUser, description: "Delete a user", role: role.Admin, action: actionDeleteUser, handler: (*bot).handleDeleteUser})
+	commands.register(command{name: cmdAudit, description: "Show the latest security-relevant actions", usage: "[telegram ID or action]", role: role.Admin, handler: (*bot).handleAudit, parseArgs: optionalArgs(1)})
+	commands.register(command{name: cmdRoles, description: "List all members with an elevated role", role: role.Owner, handler: (*bot).handleRoles})
+	commands.register(command{name: cmdGrant, description: "Grant a role to a user", usage: grantUsage, role: role.Owner, handler: (*bot).handleGrant, parseArgs: optionalArgs(2)})
+	commands.register(command{name: cmdRevoke, description: "Revoke the role of a user", usage: "<telegram ID>", role: role.Owner, handler: (*bot).handleRevoke, parseArgs: optionalArgs(1)})
+}
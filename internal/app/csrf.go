@@ -0,0 +1,65 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"net/http"
+)
+
+const (
+	csrfSessionKey = "csrf_token"
+	csrfFormField  = "csrf_token"
+	csrfHeader     = "X-CSRF-Token"
+)
+
+// csrfToken returns the csrf token of the session. A new token is created and stored in the session if necessary.
+func (a *App) csrfToken(w http.ResponseWriter, r *http.Request) string {
+	session, _ := a.sessionStore.Get(r, sessionName)
+	if t, ok := session.Values[csrfSessionKey].(string); ok && t != "" {
+		return t
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		logger.LogError(err)
+		return ""
+	}
+	t := base64.RawURLEncoding.EncodeToString(b)
+	session.Values[csrfSessionKey] = t
+	logger.LogErrorIfExists(session.Save(r, w))
+	return t
+}
+
+// csrfProtect is a middleware which rejects state-changing requests without the csrf token of the session.
+// The token is either sent as form field or as X-CSRF-Token header.
+func (a *App) csrfProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session, _ := a.sessionStore.Get(r, sessionName)
+		expected, _ := session.Values[csrfSessionKey].(string)
+		submitted := r.Header.Get(csrfHeader)
+		if submitted == "" {
+			submitted = r.PostFormValue(csrfFormField)
+		}
+		if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(submitted)) != 1 {
+			logger.LogWarnf("Rejected %s %s without valid csrf token", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusForbidden)
+			renderTemplate(w, "error", struct{ ErrorMessage string }{"Your session has expired. Please reload the page and try again."})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// methodNotAllowedHandler renders the error page for requests with a method, which is not registered for the route
+func methodNotAllowedHandler(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusMethodNotAllowed)
+	renderTemplate(w, "error", struct{ ErrorMessage string }{"Method not allowed"})
+}
@@ -0,0 +1,262 @@
+package app
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"github.com/sknr/go-coinbasepro-notifier/internal/role"
+	"github.com/sknr/go-coinbasepro-notifier/internal/supervisor"
+	"github.com/sknr/go-coinbasepro-notifier/internal/watcher"
+	"gorm.io/gorm"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dashboardDateLayout = "2006-01-02"
+
+	dashboardMaxOrderEvents   = 500
+	dashboardMaxNotifications = 100
+	// csvMaxRows limits the number of rows of a csv download
+	csvMaxRows = 10000
+)
+
+// dashboardFilter restricts the orders and notifications shown on the dashboard and within the csv downloads
+type dashboardFilter struct {
+	Product string
+	From    string // Date in the format YYYY-MM-DD (inclusive)
+	To      string // Date in the format YYYY-MM-DD (inclusive)
+	since   time.Time
+	until   time.Time
+}
+
+// dashboardOrder is an order together with the events of its lifecycle
+type dashboardOrder struct {
+	OrderID   string
+	ProductID string
+	Side      string
+	OrderType string
+	Price     string
+	Size      string
+	Status    string
+	Opened    time.Time
+	Updated   time.Time
+	Events    []database.OrderEvent // Oldest first
+}
+
+// dashboardPage holds the data of the dashboard template
+type dashboardPage struct {
+	TelegramUser
+	Filter        dashboardFilter
+	Query         template.URL // Encoded filter for the csv download links
+	Products      []string
+	Watcher       supervisor.WatcherStatus
+	WatcherStatus string
+	Orders        []dashboardOrder
+	Notifications []database.Notification
+	IsAdmin       bool // Shows the link to the admin console
+}
+
+// dashboardHandler shows the order history, the notification log and the watcher status of the user
+func (a *App) dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.dashboardUser(w, r)
+	if !ok {
+		return
+	}
+	filter, err := parseDashboardFilter(r.URL.Query())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		renderTemplate(w, "error", struct{ ErrorMessage string }{err.Error()})
+		return
+	}
+
+	page := dashboardPage{TelegramUser: user, Filter: filter, Query: template.URL(filter.encode())}
+	a.db.Model(&database.OrderEvent{}).Where("telegram_id = ?", user.ID).Distinct().Order("product_id").Pluck("product_id", &page.Products)
+	page.Watcher, page.WatcherStatus = a.getWatcherStatus(user.ID)
+	page.Orders = groupOrderEvents(a.getOrderEvents(user.ID, filter, dashboardMaxOrderEvents))
+	page.Notifications = a.getNotifications(user.ID, filter, dashboardMaxNotifications)
+	page.IsAdmin = a.roles.Get(user.ID).AtLeast(role.Admin)
+	renderTemplate(w, "dashboard", page)
+}
+
+// ordersCSVHandler downloads the order events of the user as csv
+func (a *App) ordersCSVHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.dashboardUser(w, r)
+	if !ok {
+		return
+	}
+	filter, err := parseDashboardFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows := [][]string{{"order_id", "time", "type", "reason", "product_id", "side", "order_type", "price", "size", "remaining_size", "funds"}}
+	for _, e := range a.getOrderEvents(user.ID, filter, csvMaxRows) {
+		rows = append(rows, []string{e.OrderID, e.Time.UTC().Format(time.RFC3339), e.Type, e.Reason, e.ProductID, e.Side, e.OrderType, e.Price, e.Size, e.RemainingSize, e.Funds})
+	}
+	writeCSV(w, "orders.csv", rows)
+}
+
+// notificationsCSVHandler downloads the notification log of the user as csv
+func (a *App) notificationsCSVHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.dashboardUser(w, r)
+	if !ok {
+		return
+	}
+	filter, err := parseDashboardFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows := [][]string{{"time", "product_id", "delivered", "error", "text"}}
+	for _, n := range a.getNotifications(user.ID, filter, csvMaxRows) {
+		rows = append(rows, []string{n.CreatedAt.UTC().Format(time.RFC3339), n.ProductID, strconv.FormatBool(n.Delivered), n.Error, n.Text})
+	}
+	writeCSV(w, "notifications.csv", rows)
+}
+
+// dashboardUser returns the logged in user. Anonymous users are redirected to the login page.
+func (a *App) dashboardUser(w http.ResponseWriter, r *http.Request) (TelegramUser, bool) {
+	session, _ := a.sessionStore.Get(r, sessionName)
+	user := getUser(session)
+	if !user.IsAuthenticated {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return user, false
+	}
+	return user, true
+}
+
+// getOrderEvents returns the order events of the user matching the filter, newest first
+func (a *App) getOrderEvents(telegramID string, filter dashboardFilter, limit int) []database.OrderEvent {
+	var events []database.OrderEvent
+	err := filter.apply(a.db.Where("telegram_id = ?", telegramID), "time").
+		Order("time DESC, id DESC").Limit(limit).Find(&events).Error
+	logger.LogErrorIfExists(err, telegramID)
+	return events
+}
+
+// getNotifications returns the notifications sent to the user matching the filter, newest first
+func (a *App) getNotifications(telegramID string, filter dashboardFilter, limit int) []database.Notification {
+	var notifications []database.Notification
+	err := filter.apply(a.db.Where("telegram_id = ?", telegramID), "created_at").
+		Order("created_at DESC, id DESC").Limit(limit).Find(&notifications).Error
+	logger.LogErrorIfExists(err, telegramID)
+	return notifications
+}
+
+// getWatcherStatus returns the status of the user's watcher and a short description of it
+func (a *App) getWatcherStatus(telegramID string) (supervisor.WatcherStatus, string) {
+	s, running := a.supervisor.Status(telegramID)
+	switch {
+	case !running:
+		return s, "not running"
+	case s.Paused:
+		return s, "paused"
+	case s.State == watcher.StateSubscribed && s.Connected:
+		return s, "connected"
+	default:
+		return s, string(s.State)
+	}
+}
+
+// parseDashboardFilter parses the query parameters product, from and to
+func parseDashboardFilter(query url.Values) (dashboardFilter, error) {
+	f := dashboardFilter{
+		Product: strings.ToUpper(strings.TrimSpace(query.Get("product"))),
+		From:    query.Get("from"),
+		To:      query.Get("to"),
+	}
+	var err error
+	if f.From != "" {
+		if f.since, err = time.ParseInLocation(dashboardDateLayout, f.From, time.UTC); err != nil {
+			return f, fmt.Errorf("invalid from date %q, expected YYYY-MM-DD", f.From)
+		}
+	}
+	if f.To != "" {
+		if f.until, err = time.ParseInLocation(dashboardDateLayout, f.To, time.UTC); err != nil {
+			return f, fmt.Errorf("invalid to date %q, expected YYYY-MM-DD", f.To)
+		}
+		// The to date is inclusive
+		f.until = f.until.AddDate(0, 0, 1)
+	}
+	return f, nil
+}
+
+// apply adds the conditions of the filter to the query. column is the time column of the table.
+func (f dashboardFilter) apply(db *gorm.DB, column string) *gorm.DB {
+	if f.Product != "" {
+		db = db.Where("product_id = ?", f.Product)
+	}
+	if !f.since.IsZero() {
+		db = db.Where(column+" >= ?", f.since)
+	}
+	if !f.until.IsZero() {
+		db = db.Where(column+" < ?", f.until)
+	}
+	return db
+}
+
+// encode returns the filter as url query
+func (f dashboardFilter) encode() string {
+	values := url.Values{}
+	for key, value := range map[string]string{"product": f.Product, "from": f.From, "to": f.To} {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	return values.Encode()
+}
+
+// groupOrderEvents groups the events (newest first) by order. The orders are sorted by their latest event, newest first.
+func groupOrderEvents(events []database.OrderEvent) []dashboardOrder {
+	var orders []dashboardOrder
+	index := make(map[string]int)
+	for _, e := range events {
+		i, ok := index[e.OrderID]
+		if !ok {
+			i = len(orders)
+			index[e.OrderID] = i
+			orders = append(orders, dashboardOrder{OrderID: e.OrderID, ProductID: e.ProductID, Updated: e.Time, Status: orderStatus(e)})
+		}
+		o := &orders[i]
+		// Prepend, so the events are ordered oldest first
+		o.Events = append([]database.OrderEvent{e}, o.Events...)
+		o.Opened = e.Time
+		if e.Side != "" {
+			o.Side = e.Side
+		}
+		if e.OrderType != "" {
+			o.OrderType = e.OrderType
+		}
+		if e.Price != "" {
+			o.Price = e.Price
+		}
+		if e.Size != "" {
+			o.Size = e.Size
+		}
+	}
+	return orders
+}
+
+// orderStatus describes the state of an order after the given event
+func orderStatus(e database.OrderEvent) string {
+	if e.Type == "done" && e.Reason != "" {
+		return e.Reason
+	}
+	return e.Type
+}
+
+// writeCSV sends the rows as csv file download
+func writeCSV(w http.ResponseWriter, filename string, rows [][]string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	cw := csv.NewWriter(w)
+	logger.LogErrorIfExists(cw.WriteAll(rows))
+}
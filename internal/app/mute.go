@@ -0,0 +1,91 @@
+package app
+
+import (
+	"fmt"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"github.com/sknr/go-coinbasepro-notifier/internal/mute"
+	"strings"
+	"time"
+)
+
+// defaultMuteDuration is used by /mute if only a product is given
+const defaultMuteDuration = time.Hour
+
+func (b *bot) handleMute(req request) bool {
+	var (
+		product  string
+		duration = defaultMuteDuration
+	)
+
+	args := req.args
+	if req.data != "" {
+		// Payload of the mute buttons: <product>:<duration>
+		args = strings.SplitN(req.data, ":", 2)
+	}
+	if len(args) == 0 {
+		b.reply(formatMutes(app.mutes.Active(b.telegramID())))
+		return false
+	}
+
+	for _, arg := range args {
+		if d, err := mute.ParseDuration(arg); err == nil {
+			duration = d
+			continue
+		}
+		// Anything else must be a known product, so that an invalid duration is not taken for a product
+		if product != "" || !isProduct(strings.ToUpper(arg)) {
+			b.reply(fmt.Sprintf("Usage: %s %s", cmdMute, muteUsage))
+			return false
+		}
+		product = strings.ToUpper(arg)
+	}
+
+	until := app.mutes.Mute(b.telegramID(), product, duration)
+	scope := "all products"
+	if product != "" {
+		scope = product
+	}
+	b.reply(fmt.Sprintf("🔕 Notifications for %s muted until %s.\nSend %s to unmute.", scope, until.Format(time.RFC822), cmdUnmute))
+	return false
+}
+
+func (b *bot) handleUnmute(req request) bool {
+	product := ""
+	if len(req.args) > 0 {
+		product = strings.ToUpper(req.args[0])
+	}
+	app.mutes.Unmute(b.telegramID(), product)
+	if product == "" {
+		b.reply("🔔 All notifications unmuted.")
+		return false
+	}
+	b.reply(fmt.Sprintf("🔔 Notifications for %s unmuted.", product))
+	return false
+}
+
+// isProduct returns true if the product ID is offered by coinbase pro
+func isProduct(productID string) bool {
+	for _, id := range app.updater.GetProductIDs() {
+		if id == productID {
+			return true
+		}
+	}
+	return false
+}
+
+// formatMutes lists the active mutes of a user
+func formatMutes(mutes []database.Mute) string {
+	if len(mutes) == 0 {
+		return fmt.Sprintf("No notifications muted.\nUsage: %s %s", cmdMute, muteUsage)
+	}
+	var sb strings.Builder
+	sb.WriteString("Muted notifications:\n")
+	for _, m := range mutes {
+		scope := m.ProductID
+		if scope == "" {
+			scope = "All products"
+		}
+		sb.WriteString(fmt.Sprintf("• %s until %s\n", scope, m.Until.Format(time.RFC822)))
+	}
+	return sb.String()
+}
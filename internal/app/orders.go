@@ -0,0 +1,164 @@
+package app
+
+import (
+	"fmt"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/sknr/go-coinbasepro-notifier/internal/callback"
+	"github.com/sknr/go-coinbasepro-notifier/internal/coinbase"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"github.com/sknr/go-coinbasepro-notifier/internal/watcher"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ordersPageSize = 10
+
+func (b *bot) handleOrders(req request) bool {
+	var (
+		page    int
+		product string
+	)
+	if req.data == "" {
+		if len(req.args) > 0 {
+			product = strings.ToUpper(req.args[0])
+		}
+	} else {
+		// Payload of the paging buttons: <page>:<product>
+		parts := strings.SplitN(req.data, ":", 2)
+		page, _ = strconv.Atoi(parts[0])
+		if len(parts) == 2 {
+			product = parts[1]
+		}
+	}
+
+	var userSettings database.UserSettings
+	app.db.Where("telegram_id = ?", b.telegramID()).Limit(1).Find(&userSettings)
+	if userSettings.APIKey == "" {
+		b.reply("Your Coinbase Pro API-Settings are missing. Send /start to complete the setup.")
+		return false
+	}
+
+	orders, source := app.getOpenOrders(userSettings, product)
+	text, markup := formatOrdersPage(b.chatID, orders, source, product, page)
+	if req.data == "" {
+		_, err := b.SendMessage(text, b.chatID, &echotron.MessageOptions{ReplyMarkup: markup})
+		logger.LogErrorIfExists(err, b.chatID)
+	} else {
+		_, err := b.EditMessageText(text, echotron.NewMessageID(b.chatID, req.msg.ID), &echotron.MessageTextOptions{ReplyMarkup: markup})
+		logger.LogErrorIfExists(err, b.chatID)
+	}
+	return false
+}
+
+// getOpenOrders fetches the open orders of the user from coinbase pro.
+// If coinbase pro is not reachable, the open orders are derived from the local order store.
+func (a *App) getOpenOrders(us database.UserSettings, product string) ([]coinbase.Order, string) {
+	orders, err := coinbase.OpenOrders(coinbase.NewClient(us), product)
+	if err == nil {
+		return orders, "Coinbase Pro"
+	}
+	logger.LogWarnf("[%s] Could not fetch open orders from coinbase pro: %v", us.TelegramID, err)
+	return a.getOpenOrdersFromStore(us.TelegramID, product), "local order history"
+}
+
+// getOpenOrdersFromStore derives the open orders from the stored order events
+func (a *App) getOpenOrdersFromStore(telegramID, product string) []coinbase.Order {
+	var events []database.OrderEvent
+	query := a.db.Where("telegram_id = ?", telegramID)
+	if product != "" {
+		query = query.Where("product_id = ?", product)
+	}
+	query.Order("time").Find(&events)
+
+	open := make(map[string]*coinbase.Order)
+	var ids []string
+	for _, e := range events {
+		switch e.Type {
+		case watcher.MessageTypeReceived, watcher.MessageTypeOpen:
+			o, ok := open[e.OrderID]
+			if !ok {
+				o = &coinbase.Order{ID: e.OrderID, ProductID: e.ProductID, Side: e.Side, Type: e.OrderType, CreatedAt: e.Time}
+				open[e.OrderID] = o
+				ids = append(ids, e.OrderID)
+			}
+			if e.Price != "" {
+				o.Price = e.Price
+			}
+			if e.Size != "" {
+				o.Size = e.Size
+			} else if e.RemainingSize != "" {
+				o.Size = e.RemainingSize
+			}
+		case watcher.MessageTypeDone:
+			delete(open, e.OrderID)
+		}
+	}
+
+	var orders []coinbase.Order
+	for _, id := range ids {
+		if o, ok := open[id]; ok {
+			orders = append(orders, *o)
+		}
+	}
+	coinbase.SortOrders(orders)
+	return orders
+}
+
+// formatOrdersPage renders a page of orders grouped by product together with the paging buttons
+func formatOrdersPage(chatID int64, orders []coinbase.Order, source, product string, page int) (string, echotron.InlineKeyboardMarkup) {
+	var markup echotron.InlineKeyboardMarkup
+	if len(orders) == 0 {
+		if product != "" {
+			return fmt.Sprintf("You have no open %s orders.", product), markup
+		}
+		return "You have no open orders.", markup
+	}
+
+	pages := (len(orders) + ordersPageSize - 1) / ordersPageSize
+	if page < 0 || page >= pages {
+		page = 0
+	}
+	start := page * ordersPageSize
+	end := start + ordersPageSize
+	if end > len(orders) {
+		end = len(orders)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Open orders (%d) from %s - page %d/%d\n", len(orders), source, page+1, pages))
+	lastProduct := ""
+	for _, o := range orders[start:end] {
+		if o.ProductID != lastProduct {
+			sb.WriteString(fmt.Sprintf("\n%s\n", o.ProductID))
+			lastProduct = o.ProductID
+		}
+		sb.WriteString(fmt.Sprintf("• %s %s @ %s (%s ago)\n", strings.ToUpper(o.Side), o.Size, o.Price, formatAge(time.Since(o.CreatedAt))))
+	}
+
+	var row []echotron.InlineKeyboardButton
+	if page > 0 {
+		row = append(row, echotron.InlineKeyboardButton{Text: "◀ Previous", CallbackData: callback.Encode(chatID, actionOrdersPage, fmt.Sprintf("%d:%s", page-1, product))})
+	}
+	if page < pages-1 {
+		row = append(row, echotron.InlineKeyboardButton{Text: "Next ▶", CallbackData: callback.Encode(chatID, actionOrdersPage, fmt.Sprintf("%d:%s", page+1, product))})
+	}
+	if len(row) > 0 {
+		markup.InlineKeyboard = [][]echotron.InlineKeyboardButton{row}
+	}
+
+	return sb.String(), markup
+}
+
+// formatAge formats a duration in a short human-readable form, e.g. "3d 4h"
+func formatAge(d time.Duration) string {
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd %dh", int(d.Hours())/24, int(d.Hours())%24)
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh %dm", int(d.Hours()), int(d.Minutes())%60)
+	default:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+}
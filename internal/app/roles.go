@@ -0,0 +1,80 @@
+package app
+
+import (
+	"fmt"
+	"github.com/sknr/go-coinbasepro-notifier/internal/audit"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"github.com/sknr/go-coinbasepro-notifier/internal/role"
+	"github.com/sknr/go-coinbasepro-notifier/internal/telegram"
+	"strconv"
+	"strings"
+)
+
+func (b *bot) handleRoles(_ request) bool {
+	b.reply(formatMembers(app.roles.Members(role.Support)))
+	return false
+}
+
+func (b *bot) handleGrant(req request) bool {
+	if len(req.args) != 2 {
+		b.reply(fmt.Sprintf("Usage: %s %s", cmdGrant, grantUsage))
+		return false
+	}
+	telegramID := req.args[0]
+	r, err := role.Parse(req.args[1])
+	if _, idErr := strconv.ParseInt(telegramID, 10, 64); idErr != nil || err != nil || r == role.User {
+		b.reply(fmt.Sprintf("Usage: %s %s", cmdGrant, grantUsage))
+		return false
+	}
+	b.setRole(telegramID, r)
+	return false
+}
+
+func (b *bot) handleRevoke(req request) bool {
+	if len(req.args) != 1 {
+		b.reply(fmt.Sprintf("Usage: %s <telegram ID>", cmdRevoke))
+		return false
+	}
+	b.setRole(req.args[0], role.User)
+	return false
+}
+
+// setRole changes the role of a user, updates his/her command menu and records the change within the audit trail
+func (b *bot) setRole(telegramID string, r role.Role) {
+	previous := app.roles.Get(telegramID)
+	if err := app.roles.Set(telegramID, r, b.telegramID()); err != nil {
+		b.reply(fmt.Sprintf("The role of %s could not be changed: %v", telegramID, err))
+		return
+	}
+	commands.publishMemberCommands(b.API, telegramID, r)
+
+	if r == role.User {
+		app.audit.Record(b.actor(), audit.ActionRoleRevoked, telegramID, fmt.Sprintf("Role %s revoked", previous))
+		telegram.SendPushMessage(telegramID, fmt.Sprintf("Your role %q has been revoked.", previous))
+		b.reply(fmt.Sprintf("Role %s of %s revoked.", previous, telegramID))
+		return
+	}
+	app.audit.Record(b.actor(), audit.ActionRoleGranted, telegramID, fmt.Sprintf("Role %s granted (previously %s)", r, previous))
+	telegram.SendPushMessage(telegramID, fmt.Sprintf("You have been granted the role %q. Send %s to see your commands.", r, cmdHelp))
+	b.reply(fmt.Sprintf("Role %s granted to %s.", r, telegramID))
+}
+
+// formatMembers lists the members with an elevated role together with their names
+func formatMembers(members []role.Member) string {
+	if len(members) == 0 {
+		return "No members with an elevated role."
+	}
+	var sb strings.Builder
+	sb.WriteString("Members with an elevated role:\n")
+	for _, m := range members {
+		var us database.UserSettings
+		app.db.Where("telegram_id = ?", m.TelegramID).Limit(1).Find(&us)
+		name := strings.TrimSpace(us.FirstName + " " + us.LastName)
+		if name == "" {
+			name = "unknown"
+		}
+		sb.WriteString(fmt.Sprintf("• %s: %s (%s)\n", m.Role, name, m.TelegramID))
+	}
+	sb.WriteString(fmt.Sprintf("\nUsage: %s %s", cmdGrant, grantUsage))
+	return sb.String()
+}
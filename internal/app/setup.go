@@ -0,0 +1,94 @@
+package app
+
+import (
+	"fmt"
+	"github.com/sknr/go-coinbasepro-notifier/internal/coinbase"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"strings"
+)
+
+// Steps of the /setup conversation
+const (
+	setupStepKey = iota
+	setupStepPassphrase
+	setupStepSecret
+)
+
+// Keys of the values stored within the /setup conversation
+const (
+	setupValueKey        = "key"
+	setupValuePassphrase = "passphrase"
+)
+
+// handleSetup guides the user through entering the coinbase pro api credentials.
+// Messages containing credentials are deleted right after reading them.
+func (b *bot) handleSetup(req request) bool {
+	if req.input == "" {
+		req.state.Step = setupStepKey
+		b.reply(fmt.Sprintf("Let's set up your Coinbase Pro API-Key. The only required permission is \"view\".\nYour messages containing the credentials will be deleted immediately.\nSend %s to abort.\n\nPlease enter your API-Key:", cmdCancel))
+		return true
+	}
+
+	// Remove the secret from the chat history
+	_, err := b.DeleteMessage(b.chatID, req.msg.ID)
+	logger.LogErrorIfExists(err, b.chatID)
+
+	value := strings.TrimSpace(req.input)
+	// The credentials are only kept in memory, so they are gone after a restart of the bot
+	if req.state.Step > setupStepKey && req.state.Get(setupValueKey) == "" {
+		b.reply(fmt.Sprintf("Your setup has expired. Please send %s to start again.", cmdSetup))
+		return false
+	}
+	switch req.state.Step {
+	case setupStepKey:
+		req.state.SetSecret(setupValueKey, value)
+		req.state.Step = setupStepPassphrase
+		b.reply("Please enter your API-Passphrase:")
+		return true
+	case setupStepPassphrase:
+		req.state.SetSecret(setupValuePassphrase, value)
+		req.state.Step = setupStepSecret
+		b.reply("Please enter your API-Secret:")
+		return true
+	}
+
+	b.completeSetup(req, req.state.Get(setupValueKey), req.state.Get(setupValuePassphrase), value)
+	return false
+}
+
+// completeSetup validates the credentials, stores them and starts the watcher
+func (b *bot) completeSetup(req request, key, passphrase, secret string) {
+	telegramID := b.telegramID()
+	var userSettings database.UserSettings
+	app.db.Where("telegram_id = ?", telegramID).Limit(1).Find(&userSettings)
+	if userSettings.TelegramID == "" && app.getTotalNumberOfActiveUsers() >= maxNumberOfUsers {
+		b.reply("Maximum number of users reached! Please try again later")
+		return
+	}
+
+	candidate := database.UserSettings{APIKey: key, APIPassphrase: passphrase, APISecret: secret}
+	if _, err := coinbase.NewClient(candidate).GetAccounts(); err != nil {
+		logger.LogInfof("[%s] Invalid coinbase pro credentials entered via bot: %v", telegramID, err)
+		b.reply(fmt.Sprintf("Coinbase Pro rejected your API-Key: %v\nPlease check your credentials and send %s to try again.", err, cmdSetup))
+		return
+	}
+
+	if userSettings.TelegramID == "" {
+		app.createOrUpdateUser(TelegramUser{
+			ID:        telegramID,
+			Alias:     req.msg.Chat.Username,
+			FirstName: req.msg.Chat.FirstName,
+			LastName:  req.msg.Chat.LastName,
+		})
+		app.db.Where("telegram_id = ?", telegramID).Limit(1).Find(&userSettings)
+	}
+	userSettings = app.updateAPISettings(b.actor(), userSettings, key, passphrase, secret)
+	logger.LogInfof("[%s] API-Settings updated via bot", telegramID)
+
+	if !userSettings.Active {
+		b.reply("✅ Your API-Key has been saved. You will receive notifications as soon as your account has been activated.")
+		return
+	}
+	b.reply(fmt.Sprintf("✅ Your API-Key has been saved and your notifications are active. Send %s to check the state.", cmdStatus))
+}
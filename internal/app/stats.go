@@ -0,0 +1,84 @@
+package app
+
+import (
+	"fmt"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"strings"
+	"time"
+)
+
+// statsPeriod is the period of the event based metrics of /stats
+const statsPeriod = 24 * time.Hour
+
+// instanceStats holds the metrics shown by /stats
+type instanceStats struct {
+	Version              string
+	Uptime               time.Duration
+	RegisteredUsers      int64
+	ActiveUsers          int64
+	Watchers             int
+	ConnectedWatchers    int
+	PausedWatchers       int
+	Reconnects           int
+	WatcherErrors        int
+	Products             int
+	MessagesProcessed    int64
+	NotificationsSent    int64
+	NotificationsFailed  int64
+	DeliveryFailureRatio float64
+}
+
+func (b *bot) handleStats(_ request) bool {
+	b.reply(formatStats(app.getStats(time.Now())))
+	return false
+}
+
+// getStats collects the metrics of the instance. Event based metrics cover the statsPeriod before now.
+func (a *App) getStats(now time.Time) instanceStats {
+	stats := instanceStats{
+		Version:  version,
+		Uptime:   now.Sub(a.startedAt),
+		Products: len(a.updater.GetProductIDs()),
+	}
+
+	a.db.Model(&database.UserSettings{}).Count(&stats.RegisteredUsers)
+	a.db.Model(&database.UserSettings{}).Where("active = ?", true).Count(&stats.ActiveUsers)
+
+	for _, s := range a.supervisor.Statuses() {
+		stats.Watchers++
+		if s.Connected {
+			stats.ConnectedWatchers++
+		}
+		if s.Paused {
+			stats.PausedWatchers++
+		}
+		stats.Reconnects += s.Reconnects
+		stats.WatcherErrors += s.ErrorCount
+	}
+
+	since := now.Add(-statsPeriod)
+	a.db.Model(&database.OrderEvent{}).Where("created_at >= ?", since).Count(&stats.MessagesProcessed)
+	a.db.Model(&database.Notification{}).Where("created_at >= ? AND delivered = ?", since, true).Count(&stats.NotificationsSent)
+	a.db.Model(&database.Notification{}).Where("created_at >= ? AND delivered = ?", since, false).Count(&stats.NotificationsFailed)
+	if total := stats.NotificationsSent + stats.NotificationsFailed; total > 0 {
+		stats.DeliveryFailureRatio = float64(stats.NotificationsFailed) / float64(total)
+	}
+
+	return stats
+}
+
+// formatStats creates the /stats output
+func formatStats(s instanceStats) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Coinbase Pro Notifier %s\n", s.Version))
+	sb.WriteString(fmt.Sprintf("Uptime: %s\n", formatAge(s.Uptime)))
+	sb.WriteString(fmt.Sprintf("Subscribed products: %d\n\n", s.Products))
+	sb.WriteString(fmt.Sprintf("Users: %d registered | %d active\n", s.RegisteredUsers, s.ActiveUsers))
+	sb.WriteString(fmt.Sprintf("Watchers: %d running | %d connected | %d paused\n", s.Watchers, s.ConnectedWatchers, s.PausedWatchers))
+	sb.WriteString(fmt.Sprintf("Reconnects: %d | Errors: %d\n\n", s.Reconnects, s.WatcherErrors))
+	sb.WriteString(fmt.Sprintf("Last %.0f hours:\n", statsPeriod.Hours()))
+	sb.WriteString(fmt.Sprintf("Order messages processed: %d\n", s.MessagesProcessed))
+	sb.WriteString(fmt.Sprintf("Notifications sent: %d | failed: %d\n", s.NotificationsSent, s.NotificationsFailed))
+	sb.WriteString(fmt.Sprintf("Delivery failure rate: %.1f%%\n", s.DeliveryFailureRatio*100))
+	return sb.String()
+}
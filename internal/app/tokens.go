@@ -0,0 +1,90 @@
+package app
+
+import (
+	"fmt"
+	"github.com/sknr/go-coinbasepro-notifier/internal/audit"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"github.com/sknr/go-coinbasepro-notifier/internal/role"
+	"github.com/sknr/go-coinbasepro-notifier/internal/token"
+	"net/http"
+	"strconv"
+)
+
+// profilePage holds the data of the profile template
+type profilePage struct {
+	database.UserSettings
+	Tokens     []database.APIToken
+	NewToken   string // Plaintext of a just created token, which is shown only once
+	TokenError string
+	CSRFToken  string
+	IsAdmin    bool // Shows the link to the admin console
+}
+
+func (a *App) newProfilePage(w http.ResponseWriter, r *http.Request, us database.UserSettings) profilePage {
+	return profilePage{
+		UserSettings: us,
+		Tokens:       a.tokens.List(us.TelegramID),
+		CSRFToken:    a.csrfToken(w, r),
+		IsAdmin:      a.roles.Get(us.TelegramID).AtLeast(role.Admin),
+	}
+}
+
+// createTokenHandler creates a personal api token and shows it once on the profile page
+func (a *App) createTokenHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := a.sessionStore.Get(r, sessionName)
+	user := getUser(session)
+	if !user.IsAuthenticated {
+		renderTemplate(w, "error", struct{ ErrorMessage string }{"Access denied"})
+		return
+	}
+	var userSettings database.UserSettings
+	a.db.Where("telegram_id = ?", user.ID).Limit(1).Find(&userSettings)
+	if userSettings.TelegramID == "" {
+		renderTemplate(w, "error", struct{ ErrorMessage string }{"Profile not found"})
+		return
+	}
+
+	// The write scope always includes the read scope
+	scopes := []string{token.ScopeRead}
+	if r.PostFormValue("scope") == token.ScopeWrite {
+		scopes = append(scopes, token.ScopeWrite)
+	}
+	plaintext, t, err := a.tokens.Create(user.ID, r.PostFormValue("name"), scopes)
+	page := a.newProfilePage(w, r, userSettings)
+	if err != nil {
+		logger.LogInfof("[%s] Token could not be created: %v", user.ID, err)
+		page.TokenError = err.Error()
+		renderTemplate(w, "profile", page)
+		return
+	}
+	a.audit.Record(audit.WebActor(user.ID, r), audit.ActionTokenCreated, user.ID, fmt.Sprintf("Token %q (%s) with scopes %s", t.Name, t.Prefix, t.Scopes))
+
+	// Do not cache the page containing the plaintext token
+	w.Header().Set("Cache-Control", "no-store")
+	page.NewToken = plaintext
+	renderTemplate(w, "profile", page)
+}
+
+// revokeTokenHandler deletes a personal api token of the user
+func (a *App) revokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	session, _ := a.sessionStore.Get(r, sessionName)
+	user := getUser(session)
+	if !user.IsAuthenticated {
+		renderTemplate(w, "error", struct{ ErrorMessage string }{"Access denied"})
+		return
+	}
+
+	id, err := strconv.ParseUint(r.PostFormValue("id"), 10, 64)
+	if err != nil {
+		renderTemplate(w, "error", struct{ ErrorMessage string }{"Invalid token"})
+		return
+	}
+	t, err := a.tokens.Revoke(user.ID, uint(id))
+	if err != nil {
+		renderTemplate(w, "error", struct{ ErrorMessage string }{err.Error()})
+		return
+	}
+	a.audit.Record(audit.WebActor(user.ID, r), audit.ActionTokenRevoked, user.ID, fmt.Sprintf("Token %q (%s)", t.Name, t.Prefix))
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
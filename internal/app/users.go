@@ -0,0 +1,232 @@
+package app
+
+import (
+	"fmt"
+	"github.com/NicoNex/echotron/v3"
+	"github.com/sknr/go-coinbasepro-notifier/internal/callback"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"github.com/sknr/go-coinbasepro-notifier/internal/role"
+	"github.com/sknr/go-coinbasepro-notifier/internal/supervisor"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	usersPageSize = 8
+	// usersMaxQueryLength keeps the search query within the 64 bytes of telegram callback data
+	usersMaxQueryLength = 16
+)
+
+// Operations of the /users browser, which are encoded within the callback payload
+const (
+	usersOpList          = "list"
+	usersOpShow          = "show"
+	usersOpEnable        = "on"
+	usersOpDisable       = "off"
+	usersOpRestart       = "restart"
+	usersOpDelete        = "del"
+	usersOpConfirmDelete = "rm"
+)
+
+// usersView identifies the list page of the /users browser, to which the detail card returns
+type usersView struct {
+	page  int
+	query string
+}
+
+func (b *bot) handleUsers(req request) bool {
+	if req.data == "" {
+		view := usersView{query: truncateQuery(req.argText)}
+		text, markup := formatUsersPage(b.chatID, view)
+		_, err := b.SendMessage(text, b.chatID, &echotron.MessageOptions{ReplyMarkup: markup})
+		logger.LogErrorIfExists(err, b.chatID)
+		return false
+	}
+
+	// Payload of the browser buttons: <op>:<telegram ID>:<page>:<query>
+	parts := strings.SplitN(req.data, ":", 4)
+	if len(parts) != 4 {
+		return false
+	}
+	op, telegramID := parts[0], parts[1]
+	page, _ := strconv.Atoi(parts[2])
+	view := usersView{page: page, query: parts[3]}
+
+	var (
+		text   string
+		markup echotron.InlineKeyboardMarkup
+	)
+	switch op {
+	case usersOpList:
+		text, markup = formatUsersPage(b.chatID, view)
+	case usersOpShow:
+		text, markup = formatUserCard(b.chatID, telegramID, view, false)
+	case usersOpEnable:
+		b.enableUser(telegramID)
+		text, markup = formatUserCard(b.chatID, telegramID, view, false)
+	case usersOpDisable:
+		b.disableUser(telegramID)
+		text, markup = formatUserCard(b.chatID, telegramID, view, false)
+	case usersOpRestart:
+		b.restartWatcher(telegramID)
+		text, markup = formatUserCard(b.chatID, telegramID, view, false)
+	case usersOpDelete:
+		text, markup = formatUserCard(b.chatID, telegramID, view, true)
+	case usersOpConfirmDelete:
+		b.deleteUser(telegramID)
+		text, markup = formatUsersPage(b.chatID, view)
+	default:
+		return false
+	}
+
+	_, err := b.EditMessageText(text, echotron.NewMessageID(b.chatID, req.msg.ID), &echotron.MessageTextOptions{ReplyMarkup: markup})
+	logger.LogErrorIfExists(err, b.chatID)
+	return false
+}
+
+// formatUsersPage renders a page of users matching the query with one button per user and the paging buttons
+func formatUsersPage(chatID int64, view usersView) (string, echotron.InlineKeyboardMarkup) {
+	var markup echotron.InlineKeyboardMarkup
+	if view.page < 0 {
+		view.page = 0
+	}
+	users, total := app.searchUsers(view.query, view.page*usersPageSize, usersPageSize)
+	if total == 0 {
+		if view.query != "" {
+			return fmt.Sprintf("No users found for %q.", view.query), markup
+		}
+		return "No users registered yet.", markup
+	}
+	pages := int((total + usersPageSize - 1) / usersPageSize)
+	if len(users) == 0 {
+		// The requested page does not exist anymore, e.g. after a user has been deleted
+		view.page = pages - 1
+		users, _ = app.searchUsers(view.query, view.page*usersPageSize, usersPageSize)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Users (%d) - page %d/%d\n", total, view.page+1, pages))
+	if view.query != "" {
+		sb.WriteString(fmt.Sprintf("Search: %q\n", view.query))
+	}
+
+	for _, us := range users {
+		state := "⏸"
+		if us.Active {
+			state = "▶"
+		}
+		markup.InlineKeyboard = append(markup.InlineKeyboard, []echotron.InlineKeyboardButton{{
+			Text:         fmt.Sprintf("%s %s %s (%s)", state, us.FirstName, us.LastName, us.TelegramID),
+			CallbackData: encodeUsersAction(chatID, usersOpShow, us.TelegramID, view),
+		}})
+	}
+
+	var row []echotron.InlineKeyboardButton
+	if view.page > 0 {
+		row = append(row, echotron.InlineKeyboardButton{Text: "◀ Previous", CallbackData: encodeUsersAction(chatID, usersOpList, "", usersView{page: view.page - 1, query: view.query})})
+	}
+	if view.page < pages-1 {
+		row = append(row, echotron.InlineKeyboardButton{Text: "Next ▶", CallbackData: encodeUsersAction(chatID, usersOpList, "", usersView{page: view.page + 1, query: view.query})})
+	}
+	if len(row) > 0 {
+		markup.InlineKeyboard = append(markup.InlineKeyboard, row)
+	}
+
+	return sb.String(), markup
+}
+
+// formatUserCard renders the details of a single user together with the action buttons.
+// If confirmDelete is set, the buttons ask for the confirmation of the deletion instead.
+func formatUserCard(chatID int64, telegramID string, view usersView, confirmDelete bool) (string, echotron.InlineKeyboardMarkup) {
+	back := echotron.InlineKeyboardButton{Text: "« Back to list", CallbackData: encodeUsersAction(chatID, usersOpList, "", view)}
+	markup := echotron.InlineKeyboardMarkup{InlineKeyboard: [][]echotron.InlineKeyboardButton{{back}}}
+
+	var us database.UserSettings
+	app.db.Where("telegram_id = ?", telegramID).Limit(1).Find(&us)
+	if us.TelegramID == "" {
+		return fmt.Sprintf("User with ID (%s) does not exist anymore.", telegramID), markup
+	}
+	status, running := app.supervisor.Status(telegramID)
+	text := formatUserDetails(us, app.roles.Get(telegramID), status, running, app.getLastOrderEventTime(telegramID))
+
+	if confirmDelete {
+		markup.InlineKeyboard = [][]echotron.InlineKeyboardButton{{
+			{Text: "🗑 Yes, delete", CallbackData: encodeUsersAction(chatID, usersOpConfirmDelete, telegramID, view)},
+			{Text: "Cancel", CallbackData: encodeUsersAction(chatID, usersOpShow, telegramID, view)},
+		}}
+		return text + "\nDo you really want to delete this user?", markup
+	}
+
+	toggle := echotron.InlineKeyboardButton{Text: "▶ Enable", CallbackData: encodeUsersAction(chatID, usersOpEnable, telegramID, view)}
+	if us.Active {
+		toggle = echotron.InlineKeyboardButton{Text: "⏸ Disable", CallbackData: encodeUsersAction(chatID, usersOpDisable, telegramID, view)}
+	}
+	actions := []echotron.InlineKeyboardButton{toggle}
+	if us.Active {
+		actions = append(actions, echotron.InlineKeyboardButton{Text: "🔄 Restart", CallbackData: encodeUsersAction(chatID, usersOpRestart, telegramID, view)})
+	}
+	actions = append(actions, echotron.InlineKeyboardButton{Text: "🗑 Delete", CallbackData: encodeUsersAction(chatID, usersOpDelete, telegramID, view)})
+	markup.InlineKeyboard = [][]echotron.InlineKeyboardButton{
+		actions,
+		{
+			{Text: "🔃 Refresh", CallbackData: encodeUsersAction(chatID, usersOpShow, telegramID, view)},
+			back,
+		},
+	}
+
+	return text, markup
+}
+
+// formatUserDetails creates the detail card of a user for admins
+func formatUserDetails(us database.UserSettings, r role.Role, s supervisor.WatcherStatus, running bool, lastOrderEvent time.Time) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%s %s (%s)\n", us.FirstName, us.LastName, us.TelegramID))
+	if us.Username != "" {
+		sb.WriteString(fmt.Sprintf("Username: @%s\n", us.Username))
+	}
+	sb.WriteString(fmt.Sprintf("Role: %s\n", r))
+	sb.WriteString(fmt.Sprintf("Active: %s\n", yesNo(us.Active)))
+	sb.WriteString(fmt.Sprintf("API-Key set: %s\n", yesNo(us.APIKey != "")))
+	sb.WriteString(fmt.Sprintf("Created: %s\n", us.CreatedAt.Format(time.RFC822)))
+
+	state := "not running"
+	switch {
+	case s.Paused:
+		state = "paused (" + s.PauseReason + ")"
+	case running:
+		state = fmt.Sprintf("%s since %s", s.State, s.Since.Format(time.RFC822))
+	}
+	sb.WriteString(fmt.Sprintf("Watcher: %s\n", state))
+
+	lastEvent := s.LastMessageAt
+	if lastOrderEvent.After(lastEvent) {
+		lastEvent = lastOrderEvent
+	}
+	if lastEvent.IsZero() {
+		sb.WriteString("Last event: never\n")
+	} else {
+		sb.WriteString(fmt.Sprintf("Last event: %s (%s ago)\n", lastEvent.Format(time.RFC822), formatAge(time.Since(lastEvent))))
+	}
+	sb.WriteString(fmt.Sprintf("Errors: %d | Reconnects: %d\n", s.ErrorCount, s.Reconnects))
+	if s.LastError != "" {
+		sb.WriteString(fmt.Sprintf("Last error: %s (%s)\n", s.LastError, s.LastErrorAt.Format(time.RFC822)))
+	}
+	return sb.String()
+}
+
+// encodeUsersAction creates the signed callback data of a /users browser button
+func encodeUsersAction(chatID int64, op, telegramID string, view usersView) string {
+	return callback.Encode(chatID, actionUsers, fmt.Sprintf("%s:%s:%d:%s", op, telegramID, view.page, view.query))
+}
+
+// truncateQuery shortens the search query to usersMaxQueryLength bytes without splitting an utf-8 character
+func truncateQuery(query string) string {
+	for len(query) > usersMaxQueryLength {
+		_, size := utf8.DecodeLastRuneInString(query)
+		query = query[:len(query)-size]
+	}
+	return strings.TrimSpace(query)
+}
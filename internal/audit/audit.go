@@ -0,0 +1,198 @@
+package audit
+
+import (
+	"fmt"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"gorm.io/gorm"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Actions recorded within the audit trail
+const (
+	ActionLogin              = "auth.login"
+	ActionLoginFailed        = "auth.login_failed"
+	ActionLogout             = "auth.logout"
+	ActionCredentialsChanged = "credentials.update"
+	ActionProfileDeleted     = "profile.delete"
+	ActionTokenCreated       = "token.create"
+	ActionTokenRevoked       = "token.revoke"
+	ActionUserEnabled        = "user.enable"
+	ActionUserDisabled       = "user.disable"
+	ActionUserDeleted        = "user.delete"
+	ActionWatcherRestarted   = "watcher.restart"
+	ActionWatcherPaused      = "watcher.pause"
+	ActionBroadcastSent      = "broadcast.send"
+	ActionRoleGranted        = "role.grant"
+	ActionRoleRevoked        = "role.revoke"
+)
+
+// Sources of an action
+const (
+	SourceWeb    = "web"
+	SourceBot    = "bot"
+	SourceSystem = "system"
+)
+
+// Anonymous is the actor ID of unauthenticated requests
+const Anonymous = "anonymous"
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+// Actor describes who performed an action and from where
+type Actor struct {
+	ID      string
+	Source  string
+	Address string
+}
+
+// BotActor returns the actor of a bot command sent from the given chat
+func BotActor(chatID string) Actor {
+	return Actor{ID: chatID, Source: SourceBot, Address: "chat:" + chatID}
+}
+
+// SystemActor returns the actor of actions the app performs on its own
+func SystemActor() Actor {
+	return Actor{ID: SourceSystem, Source: SourceSystem}
+}
+
+// trustedProxies are the reverse proxies, whose X-Forwarded-For header is trusted
+var trustedProxies []*net.IPNet
+
+// SetTrustedProxies configures the reverse proxies in front of the app as comma separated list of IP addresses or CIDR ranges.
+// It must be called before the first request is served.
+func SetTrustedProxies(proxies string) error {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(proxies, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return fmt.Errorf("invalid TRUSTED_PROXIES entry %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("invalid TRUSTED_PROXIES entry %q", entry)
+		}
+		nets = append(nets, ipNet)
+	}
+	trustedProxies = nets
+	return nil
+}
+
+// WebActor returns the actor of a web request.
+// The address is only taken from the X-Forwarded-For header if the request has been forwarded by a trusted proxy.
+func WebActor(telegramID string, r *http.Request) Actor {
+	return Actor{ID: telegramID, Source: SourceWeb, Address: clientAddress(r)}
+}
+
+// clientAddress returns the address of the client. Starting at the direct peer, the X-Forwarded-For
+// chain is followed backwards as long as the addresses belong to trusted proxies.
+func clientAddress(r *http.Request) string {
+	address := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		address = host
+	}
+	if !isTrustedProxy(address) {
+		return address
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		address = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return address
+}
+
+// isTrustedProxy returns true if the address belongs to one of the trusted proxies
+func isTrustedProxy(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter restricts the audit events returned by Query
+type Filter struct {
+	UserID string    // Matches either the actor or the target
+	Action string    // Matches the action or an action prefix like "auth"
+	Since  time.Time // Only events created at or after this time
+	Limit  int       // Defaults to 50, at most 500
+}
+
+// Log records security-relevant actions in the database
+type Log struct {
+	db *gorm.DB
+}
+
+func NewLog(db *gorm.DB) *Log {
+	return &Log{db: db}
+}
+
+// Record stores an action which has been performed by the actor on the target
+func (l *Log) Record(actor Actor, action, targetID, details string) {
+	logger.LogInfof("[audit] %s via %s (%s): %s %s %s", actor.ID, actor.Source, actor.Address, action, targetID, details)
+	err := l.db.Create(&database.AuditEvent{
+		ActorID:  actor.ID,
+		Action:   action,
+		TargetID: targetID,
+		Source:   actor.Source,
+		Address:  actor.Address,
+		Details:  details,
+	}).Error
+	logger.LogErrorIfExists(err, actor.ID)
+}
+
+// Query returns the audit events matching the filter, newest first
+func (l *Log) Query(f Filter) []database.AuditEvent {
+	if f.Limit <= 0 {
+		f.Limit = defaultLimit
+	}
+	if f.Limit > maxLimit {
+		f.Limit = maxLimit
+	}
+
+	query := l.db.Model(&database.AuditEvent{})
+	if f.UserID != "" {
+		query = query.Where("actor_id = ? OR target_id = ?", f.UserID, f.UserID)
+	}
+	if f.Action != "" {
+		query = query.Where("action = ? OR action LIKE ?", f.Action, f.Action+".%")
+	}
+	if !f.Since.IsZero() {
+		query = query.Where("created_at >= ?", f.Since)
+	}
+
+	var events []database.AuditEvent
+	err := query.Order("created_at DESC, id DESC").Limit(f.Limit).Find(&events).Error
+	logger.LogErrorIfExists(err)
+	return events
+}
@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebActorAddress(t *testing.T) {
+	tests := []struct {
+		name      string
+		proxies   string
+		peer      string
+		forwarded []string
+		want      string
+	}{
+		{
+			name: "direct",
+			peer: "203.0.113.7:4711",
+			want: "203.0.113.7",
+		},
+		{
+			name:      "header ignored without trusted proxies",
+			peer:      "203.0.113.7:4711",
+			forwarded: []string{"198.51.100.1"},
+			want:      "203.0.113.7",
+		},
+		{
+			name:      "header of an untrusted peer",
+			proxies:   "10.0.0.1",
+			peer:      "203.0.113.7:4711",
+			forwarded: []string{"198.51.100.1"},
+			want:      "203.0.113.7",
+		},
+		{
+			name:      "trusted proxy",
+			proxies:   "10.0.0.1",
+			peer:      "10.0.0.1:4711",
+			forwarded: []string{"198.51.100.1"},
+			want:      "198.51.100.1",
+		},
+		{
+			name:      "spoofed entries before the client",
+			proxies:   "10.0.0.0/8",
+			peer:      "10.0.0.1:4711",
+			forwarded: []string{"1.2.3.4, 198.51.100.1, 10.0.0.2"},
+			want:      "198.51.100.1",
+		},
+		{
+			name:      "multiple headers",
+			proxies:   "10.0.0.0/8, ::1",
+			peer:      "[::1]:4711",
+			forwarded: []string{"1.2.3.4", "198.51.100.1"},
+			want:      "198.51.100.1",
+		},
+		{
+			name:    "trusted proxy without header",
+			proxies: "10.0.0.1",
+			peer:    "10.0.0.1:4711",
+			want:    "10.0.0.1",
+		},
+	}
+
+	defer func() { trustedProxies = nil }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SetTrustedProxies(tt.proxies); err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.peer
+			for _, f := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", f)
+			}
+			if got := WebActor("1", r).Address; got != tt.want {
+				t.Errorf("Address = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetTrustedProxiesInvalid(t *testing.T) {
+	defer func() { trustedProxies = nil }()
+	for _, proxies := range []string{"proxy.local", "10.0.0.1/33", "10.0.0.1, nope"} {
+		if err := SetTrustedProxies(proxies); err == nil {
+			t.Errorf("SetTrustedProxies(%q) = nil, want an error", proxies)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package callback
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	signatureLength = 12 // Length of the base64 encoded signature within the callback data
+	maxDataLength   = 64 // Limit of the telegram bot api
+)
+
+// ActionMute is the action of the mute buttons attached to every order notification
+const ActionMute = "mute"
+
+// ErrInvalid is returned if the callback data has been tampered with or belongs to another chat
+var ErrInvalid = errors.New("invalid callback data")
+
+// key returns the key used for signing the callback data.
+// If CALLBACK_SECRET is not set, the key is derived from the telegram token.
+func key() []byte {
+	if secret := os.Getenv("CALLBACK_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+	derived := sha256.Sum256([]byte("callback:" + os.Getenv("TELEGRAM_TOKEN")))
+	return derived[:]
+}
+
+// Encode creates the signed callback data "<action>:<payload>:<signature>" for an inline button.
+// The signature binds the data to the given chat, so it cannot be forged or replayed within another chat.
+func Encode(chatID int64, action, payload string) string {
+	data := action + ":" + payload + ":" + sign(chatID, action, payload)
+	if len(data) > maxDataLength {
+		panic(fmt.Sprintf("Callback data %q exceeds %d bytes", data, maxDataLength))
+	}
+	return data
+}
+
+// Decode verifies the signature of the callback data and returns the action and its payload
+func Decode(chatID int64, data string) (action, payload string, err error) {
+	first := strings.Index(data, ":")
+	last := strings.LastIndex(data, ":")
+	if first < 0 || first == last {
+		return "", "", ErrInvalid
+	}
+	action, payload, signature := data[:first], data[first+1:last], data[last+1:]
+	if !hmac.Equal([]byte(signature), []byte(sign(chatID, action, payload))) {
+		return "", "", ErrInvalid
+	}
+	return action, payload, nil
+}
+
+func sign(chatID int64, action, payload string) string {
+	h := hmac.New(sha256.New, key())
+	h.Write([]byte(fmt.Sprintf("%d:%s:%s", chatID, action, payload)))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))[:signatureLength]
+}
@@ -0,0 +1,87 @@
+package coinbase
+
+import (
+	"github.com/preichenberger/go-coinbasepro/v2"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	CoinbaseProURL = "https://api.pro.coinbase.com"
+
+	OrderStatusOpen = "open"
+)
+
+// Order is an open order of a user
+type Order struct {
+	ID        string
+	ProductID string
+	Side      string
+	Type      string
+	Size      string
+	Price     string
+	CreatedAt time.Time
+}
+
+// NewClient creates a coinbase pro REST client authenticated with the API-Key of the given user
+func NewClient(us database.UserSettings) *coinbasepro.Client {
+	baseURL := os.Getenv("COINBASE_PRO_BASEURL")
+	if baseURL == "" {
+		baseURL = CoinbaseProURL
+	}
+
+	return &coinbasepro.Client{
+		BaseURL:    baseURL,
+		Key:        us.APIKey,
+		Passphrase: us.APIPassphrase,
+		Secret:     us.APISecret,
+		HTTPClient: &http.Client{
+			Timeout: 15 * time.Second,
+		},
+	}
+}
+
+// OpenOrders fetches all open orders of the client's user, optionally restricted to the given product
+func OpenOrders(client *coinbasepro.Client, productID string) ([]Order, error) {
+	var orders []Order
+	cursor := client.ListOrders(coinbasepro.ListOrdersParams{
+		Status:    OrderStatusOpen,
+		ProductID: productID,
+	})
+	for cursor.HasMore {
+		var page []coinbasepro.Order
+		if err := cursor.NextPage(&page); err != nil {
+			return nil, err
+		}
+		for _, o := range page {
+			orders = append(orders, Order{
+				ID:        o.ID,
+				ProductID: o.ProductID,
+				Side:      o.Side,
+				Type:      o.Type,
+				Size:      o.Size,
+				Price:     o.Price,
+				CreatedAt: o.CreatedAt.Time(),
+			})
+		}
+		// Coinbase pro returns an empty page at the end
+		if len(page) == 0 {
+			break
+		}
+	}
+	SortOrders(orders)
+	return orders, nil
+}
+
+// SortOrders sorts the orders by product and creation time (newest first)
+func SortOrders(orders []Order) {
+	sort.SliceStable(orders, func(i, j int) bool {
+		if orders[i].ProductID != orders[j].ProductID {
+			return orders[i].ProductID < orders[j].ProductID
+		}
+		return orders[i].CreatedAt.After(orders[j].CreatedAt)
+	})
+}
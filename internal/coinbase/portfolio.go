@@ -0,0 +1,90 @@
+package coinbase
+
+import (
+	"github.com/preichenberger/go-coinbasepro/v2"
+	"github.com/shopspring/decimal"
+	"github.com/sknr/go-coinbasepro-notifier/internal/utils"
+	"sort"
+	"strings"
+)
+
+// Asset is a non-zero account balance valued in the quote currency
+type Asset struct {
+	Currency string
+	Balance  decimal.Decimal
+	Price    decimal.Decimal // Price of one unit in the quote currency
+	Value    decimal.Decimal // Balance valued in the quote currency
+	Share    decimal.Decimal // Share of the total portfolio value in percent
+	Valued   bool            // False if no product exists to determine the price
+}
+
+// Portfolio holds all assets of a user valued in the quote currency
+type Portfolio struct {
+	Quote  string
+	Assets []Asset
+	Total  decimal.Decimal
+}
+
+// GetPortfolio fetches the non-zero account balances of the client's user and values them in the quote currency.
+// Prices are taken from the ticker of the product <currency>-<quote> or the inverse product <quote>-<currency>.
+func GetPortfolio(client *coinbasepro.Client, quote string, productIDs []string) (Portfolio, error) {
+	quote = strings.ToUpper(quote)
+	portfolio := Portfolio{Quote: quote, Total: decimal.Zero}
+
+	accounts, err := client.GetAccounts()
+	if err != nil {
+		return portfolio, err
+	}
+
+	products := make(map[string]bool, len(productIDs))
+	for _, id := range productIDs {
+		products[id] = true
+	}
+
+	for _, account := range accounts {
+		balance := utils.StringToDecimal(account.Balance)
+		if balance.IsZero() {
+			continue
+		}
+		asset := Asset{Currency: account.Currency, Balance: balance}
+		asset.Price, asset.Valued = price(client, account.Currency, quote, products)
+		if asset.Valued {
+			asset.Value = balance.Mul(asset.Price)
+			portfolio.Total = portfolio.Total.Add(asset.Value)
+		}
+		portfolio.Assets = append(portfolio.Assets, asset)
+	}
+
+	for i := range portfolio.Assets {
+		if portfolio.Assets[i].Valued && !portfolio.Total.IsZero() {
+			portfolio.Assets[i].Share = portfolio.Assets[i].Value.Div(portfolio.Total).Mul(decimal.NewFromInt(100))
+		}
+	}
+	// Most valuable assets first
+	sort.SliceStable(portfolio.Assets, func(i, j int) bool {
+		return portfolio.Assets[i].Value.GreaterThan(portfolio.Assets[j].Value)
+	})
+
+	return portfolio, nil
+}
+
+// price determines the price of one unit of currency in the quote currency
+func price(client *coinbasepro.Client, currency, quote string, products map[string]bool) (decimal.Decimal, bool) {
+	if currency == quote {
+		return decimal.NewFromInt(1), true
+	}
+	if products[currency+"-"+quote] {
+		if ticker, err := client.GetTicker(currency + "-" + quote); err == nil {
+			return utils.StringToDecimal(ticker.Price), true
+		}
+	}
+	if products[quote+"-"+currency] {
+		if ticker, err := client.GetTicker(quote + "-" + currency); err == nil {
+			p := utils.StringToDecimal(ticker.Price)
+			if !p.IsZero() {
+				return decimal.NewFromInt(1).Div(p), true
+			}
+		}
+	}
+	return decimal.Zero, false
+}
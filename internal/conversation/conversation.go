@@ -0,0 +1,137 @@
+package conversation
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"gorm.io/gorm"
+	"sync"
+	"time"
+)
+
+// State is the state of a multi-step conversation of a chat
+type State struct {
+	Command string            // Command which awaits further input
+	Step    int               // Current step of the command
+	Values  map[string]string // Values entered so far
+	Secrets map[string]string // Values entered so far, which are only kept in memory
+}
+
+// Set stores a value of the conversation
+func (s *State) Set(key, value string) {
+	if s.Values == nil {
+		s.Values = make(map[string]string)
+	}
+	s.Values[key] = value
+}
+
+// SetSecret stores a value of the conversation, which must never be written to the database
+func (s *State) SetSecret(key, value string) {
+	if s.Secrets == nil {
+		s.Secrets = make(map[string]string)
+	}
+	s.Secrets[key] = value
+}
+
+// Get returns a value or secret of the conversation
+func (s *State) Get(key string) string {
+	if value, ok := s.Secrets[key]; ok {
+		return value
+	}
+	return s.Values[key]
+}
+
+// Store persists the conversation states in the database, except for their secrets, which are kept in memory.
+// Thus, secrets get lost on restart. States expire after the configured ttl, so that abandoned conversations do not linger.
+type Store struct {
+	db      *gorm.DB
+	ttl     time.Duration
+	mu      sync.Mutex
+	secrets map[string]secrets // Secrets of the states by chat ID
+}
+
+// secrets are the in-memory values of a state
+type secrets struct {
+	values    map[string]string
+	expiresAt time.Time
+}
+
+func NewStore(db *gorm.DB, ttl time.Duration) *Store {
+	return &Store{db: db, ttl: ttl, secrets: make(map[string]secrets)}
+}
+
+// Get returns the state of the given chat, if there is one which has not yet expired.
+// Expired states are not removed, use DeleteExpired instead.
+func (s *Store) Get(chatID string) (State, bool) {
+	var c database.Conversation
+	err := s.db.Where("chat_id = ? AND expires_at > ?", chatID, time.Now()).First(&c).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			logger.LogError(err, chatID)
+		}
+		return State{}, false
+	}
+
+	state := State{Command: c.Command, Step: c.Step}
+	if c.Values != "" {
+		logger.LogErrorIfExists(json.Unmarshal([]byte(c.Values), &state.Values), chatID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.secrets[chatID]; ok {
+		for key, value := range e.values {
+			state.SetSecret(key, value)
+		}
+	}
+	return state, true
+}
+
+// Save stores the state of the given chat and renews its expiry
+func (s *Store) Save(chatID string, state State) {
+	expiresAt := time.Now().Add(s.ttl)
+	s.mu.Lock()
+	if len(state.Secrets) > 0 {
+		values := make(map[string]string, len(state.Secrets))
+		for key, value := range state.Secrets {
+			values[key] = value
+		}
+		s.secrets[chatID] = secrets{values: values, expiresAt: expiresAt}
+	} else {
+		delete(s.secrets, chatID)
+	}
+	s.mu.Unlock()
+
+	values, err := json.Marshal(state.Values)
+	logger.LogErrorIfExists(err, chatID)
+	err = s.db.Save(&database.Conversation{
+		ChatID:    chatID,
+		ExpiresAt: expiresAt,
+		Command:   state.Command,
+		Step:      state.Step,
+		Values:    string(values),
+	}).Error
+	logger.LogErrorIfExists(err, chatID)
+}
+
+// Delete removes the state of the given chat
+func (s *Store) Delete(chatID string) {
+	s.mu.Lock()
+	delete(s.secrets, chatID)
+	s.mu.Unlock()
+	logger.LogErrorIfExists(s.db.Delete(&database.Conversation{}, "chat_id = ?", chatID).Error, chatID)
+}
+
+// DeleteExpired removes all expired states
+func (s *Store) DeleteExpired() {
+	now := time.Now()
+	s.mu.Lock()
+	for chatID, e := range s.secrets {
+		if !now.Before(e.expiresAt) {
+			delete(s.secrets, chatID)
+		}
+	}
+	s.mu.Unlock()
+	logger.LogErrorIfExists(s.db.Delete(&database.Conversation{}, "expires_at <= ?", now).Error)
+}
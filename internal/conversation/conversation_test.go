@@ -0,0 +1,86 @@
+package conversation
+
+import (
+	"github.com/foxever/sqlite"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"gorm.io/gorm"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestStore creates a store on a fresh database
+func newTestStore(t *testing.T, ttl time.Duration) (*Store, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.AutoMigrate(&database.Conversation{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+	return NewStore(db, ttl), db
+}
+
+func TestSecretsAreNotPersisted(t *testing.T) {
+	store, db := newTestStore(t, time.Minute)
+	state := State{Command: "/setup", Step: 1}
+	state.Set("plain", "visible")
+	state.SetSecret("key", "top-secret")
+	store.Save("1", state)
+
+	var rows []database.Conversation
+	db.Find(&rows)
+	if len(rows) != 1 {
+		t.Fatalf("%d rows stored, want 1", len(rows))
+	}
+	if strings.Contains(rows[0].Values, "top-secret") {
+		t.Errorf("secret stored in the database: %s", rows[0].Values)
+	}
+
+	got, ok := store.Get("1")
+	if !ok || got.Get("key") != "top-secret" || got.Get("plain") != "visible" || got.Step != 1 {
+		t.Errorf("Get() = %+v, %v", got, ok)
+	}
+
+	// A new store, as after a restart, has lost the secrets
+	got, ok = NewStore(db, time.Minute).Get("1")
+	if !ok || got.Get("key") != "" || got.Get("plain") != "visible" {
+		t.Errorf("Get() after restart = %+v, %v", got, ok)
+	}
+
+	store.Delete("1")
+	if _, ok = store.Get("1"); ok {
+		t.Error("Get() returned a deleted state")
+	}
+	if len(store.secrets) != 0 {
+		t.Error("secrets kept after Delete")
+	}
+}
+
+func TestDeleteExpired(t *testing.T) {
+	store, db := newTestStore(t, time.Millisecond)
+	state := State{Command: "/setup"}
+	state.SetSecret("key", "top-secret")
+	store.Save("1", state)
+	store.Save("2", State{Command: "/broadcast"})
+	time.Sleep(5 * time.Millisecond)
+
+	if _, ok := store.Get("1"); ok {
+		t.Fatal("Get() returned an expired state")
+	}
+	store.DeleteExpired()
+	var count int64
+	db.Model(&database.Conversation{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d expired rows left after DeleteExpired", count)
+	}
+	if len(store.secrets) != 0 {
+		t.Error("expired secrets left after DeleteExpired")
+	}
+}
@@ -16,4 +16,120 @@ type UserSettings struct {
 	APIPassphrase string
 	APISecret     string
 	Active        bool
+	PausedReason  string // Set if the watcher has been paused due to invalid credentials, until they get updated
+}
+
+// OrderEvent stores an order related message received from the coinbase pro user channel
+type OrderEvent struct {
+	ID            uint      `gorm:"primaryKey"`
+	TelegramID    string    `gorm:"index"`
+	OrderID       string    `gorm:"index"`
+	Time          time.Time `gorm:"index"`
+	CreatedAt     time.Time
+	Type          string
+	Reason        string
+	ProductID     string
+	Side          string
+	OrderType     string
+	Price         string
+	Size          string
+	RemainingSize string
+	Funds         string
+	Sequence      int64
+}
+
+// Conversation stores the state of a multi-step bot conversation per chat
+type Conversation struct {
+	ChatID    string `gorm:"primaryKey"`
+	UpdatedAt time.Time
+	ExpiresAt time.Time `gorm:"index"`
+	Command   string
+	Step      int
+	Values    string // JSON encoded values entered so far
+}
+
+// Mute suppresses the order notifications of a user until the given time
+type Mute struct {
+	ID         uint   `gorm:"primaryKey"`
+	TelegramID string `gorm:"index"`
+	ProductID  string // Empty for all products
+	Until      time.Time
+	CreatedAt  time.Time
+}
+
+// Broadcast is an announcement sent by an admin to all or only the active users
+type Broadcast struct {
+	ID         uint `gorm:"primaryKey"`
+	CreatedAt  time.Time
+	CreatedBy  string
+	Text       string
+	Audience   string // "all" or "active", empty while in draft
+	Status     string `gorm:"index"`
+	Recipients int
+	Delivered  int
+	Failed     int
+	FinishedAt time.Time
+}
+
+// BroadcastDelivery stores the delivery result of a broadcast for a single recipient
+type BroadcastDelivery struct {
+	ID          uint `gorm:"primaryKey"`
+	BroadcastID uint `gorm:"index"`
+	TelegramID  string
+	CreatedAt   time.Time
+	Delivered   bool
+	Error       string
+}
+
+// Notification logs a notification sent to a user together with its delivery result
+type Notification struct {
+	ID         uint      `gorm:"primaryKey"`
+	TelegramID string    `gorm:"index"`
+	CreatedAt  time.Time `gorm:"index"`
+	ProductID  string
+	Text       string
+	Delivered  bool
+	Error      string
+}
+
+// UserRole grants a role with elevated permissions to a telegram user
+type UserRole struct {
+	TelegramID string `gorm:"primaryKey"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Role       string `gorm:"index"`
+	GrantedBy  string
+}
+
+// AuditEvent records a security-relevant action
+type AuditEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	ActorID   string    `gorm:"index" json:"actor_id"`
+	Action    string    `gorm:"index" json:"action"`
+	TargetID  string    `gorm:"index" json:"target_id"`
+	Source    string    `json:"source"`  // web, bot, cli or system
+	Address   string    `json:"address"` // IP address of a web request or the chat of a bot command
+	Details   string    `json:"details"`
+}
+
+// APIToken is a personal access token for the json api. Only the hash of the token is stored.
+type APIToken struct {
+	ID         uint   `gorm:"primaryKey"`
+	TelegramID string `gorm:"index"`
+	CreatedAt  time.Time
+	Name       string
+	Prefix     string // First characters of the token for recognizing it
+	Hash       string `gorm:"uniqueIndex"`
+	Scopes     string // Comma separated list of scopes
+	LastUsedAt time.Time
+}
+
+// Session is a web session of the database session store. The data is encrypted with the session keys.
+type Session struct {
+	ID        string `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	ExpiresAt time.Time `gorm:"index"`
+	Data      string
 }
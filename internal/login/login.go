@@ -0,0 +1,135 @@
+package login
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxAge is used if no positive max age is passed to NewVerifier
+	DefaultMaxAge = time.Hour
+	// clockSkew tolerates an auth_date slightly in the future
+	clockSkew = time.Minute
+)
+
+// Errors returned by Verify. Their messages are meant to be shown to the user.
+var (
+	ErrMissingData     = errors.New("the login data is incomplete, please log in again")
+	ErrInvalidHash     = errors.New("the login data could not be verified, someone seems to try nasty stuff")
+	ErrInvalidAuthDate = errors.New("the login data contains an invalid date, please log in again")
+	ErrExpired         = errors.New("the login link has expired, please log in again")
+	ErrReplayed        = errors.New("the login link has already been used, please log in again")
+)
+
+// User is the verified telegram user of a login
+type User struct {
+	ID        string
+	FirstName string
+	LastName  string
+	Username  string
+	PhotoURL  string
+	AuthDate  time.Time
+}
+
+// Verifier checks the data sent by the telegram login widget, see https://core.telegram.org/widgets/login#checking-authorization.
+// Each login can only be used once within the max age. It is safe for concurrent use.
+type Verifier struct {
+	secret []byte
+	maxAge time.Duration
+	now    func() time.Time // Replaced within tests
+
+	mu   sync.Mutex
+	used map[string]time.Time // Hashes of the accepted logins and their expiry
+}
+
+// NewVerifier creates a verifier for the logins of the bot with the given token.
+// Logins older than maxAge are rejected.
+func NewVerifier(botToken string, maxAge time.Duration) *Verifier {
+	if maxAge <= 0 {
+		maxAge = DefaultMaxAge
+	}
+	secret := sha256.Sum256([]byte(botToken))
+	return &Verifier{
+		secret: secret[:],
+		maxAge: maxAge,
+		now:    time.Now,
+		used:   make(map[string]time.Time),
+	}
+}
+
+// Verify checks the signature and age of the login data and returns the telegram user
+func (v *Verifier) Verify(query url.Values) (User, error) {
+	submittedHash := query.Get("hash")
+	if submittedHash == "" || query.Get("id") == "" || query.Get("auth_date") == "" {
+		return User{}, ErrMissingData
+	}
+	if !hmac.Equal([]byte(submittedHash), []byte(v.sign(query))) {
+		return User{}, ErrInvalidHash
+	}
+
+	ts, err := strconv.ParseInt(query.Get("auth_date"), 10, 64)
+	if err != nil {
+		return User{}, ErrInvalidAuthDate
+	}
+	authDate := time.Unix(ts, 0)
+	now := v.now()
+	if authDate.After(now.Add(clockSkew)) {
+		return User{}, ErrInvalidAuthDate
+	}
+	expiry := authDate.Add(v.maxAge)
+	if !now.Before(expiry) {
+		return User{}, ErrExpired
+	}
+	if !v.markUsed(submittedHash, expiry, now) {
+		return User{}, ErrReplayed
+	}
+
+	return User{
+		ID:        query.Get("id"),
+		FirstName: query.Get("first_name"),
+		LastName:  query.Get("last_name"),
+		Username:  query.Get("username"),
+		PhotoURL:  query.Get("photo_url"),
+		AuthDate:  authDate,
+	}, nil
+}
+
+// sign calculates the hash of all received fields except the hash itself
+func (v *Verifier) sign(query url.Values) string {
+	var fields []string
+	for key := range query {
+		if key != "hash" {
+			fields = append(fields, key+"="+query.Get(key))
+		}
+	}
+	sort.Strings(fields)
+
+	h := hmac.New(sha256.New, v.secret)
+	h.Write([]byte(strings.Join(fields, "\n")))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// markUsed remembers the hash until it expires. It returns false if the hash has already been used.
+// Expired hashes are removed, as they are rejected by the max age check anyway.
+func (v *Verifier) markUsed(hash string, expiry, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for h, e := range v.used {
+		if !now.Before(e) {
+			delete(v.used, h)
+		}
+	}
+	if _, ok := v.used[hash]; ok {
+		return false
+	}
+	v.used[hash] = expiry
+	return true
+}
@@ -0,0 +1,203 @@
+package login
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testBotToken = "123456:ABC-DEF1234ghIkl-zyx57W2v1u123ew11"
+	testMaxAge   = time.Hour
+)
+
+// testNow is the fixed time of the verifiers within the tests
+var testNow = time.Unix(1700000000, 0)
+
+// newTestVerifier creates a verifier for testBotToken, whose clock is set to testNow
+func newTestVerifier() *Verifier {
+	v := NewVerifier(testBotToken, testMaxAge)
+	v.now = func() time.Time { return testNow }
+	return v
+}
+
+// signedLogin creates the login data of the telegram widget, signed with testBotToken as described in
+// https://core.telegram.org/widgets/login#checking-authorization
+func signedLogin(authDate time.Time, fields map[string]string) url.Values {
+	query := url.Values{
+		"id":         {"42"},
+		"first_name": {"John"},
+		"username":   {"john"},
+		"auth_date":  {strconv.FormatInt(authDate.Unix(), 10)},
+	}
+	for key, value := range fields {
+		query.Set(key, value)
+	}
+
+	var lines []string
+	for key := range query {
+		lines = append(lines, key+"="+query.Get(key))
+	}
+	sort.Strings(lines)
+	secret := sha256.Sum256([]byte(testBotToken))
+	h := hmac.New(sha256.New, secret[:])
+	h.Write([]byte(strings.Join(lines, "\n")))
+	query.Set("hash", hex.EncodeToString(h.Sum(nil)))
+	return query
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name  string
+		query func() url.Values
+		err   error
+	}{
+		{
+			name:  "valid",
+			query: func() url.Values { return signedLogin(testNow.Add(-time.Minute), nil) },
+		},
+		{
+			name: "tampered field",
+			query: func() url.Values {
+				q := signedLogin(testNow, nil)
+				q.Set("id", "43")
+				return q
+			},
+			err: ErrInvalidHash,
+		},
+		{
+			name: "added field",
+			query: func() url.Values {
+				q := signedLogin(testNow, nil)
+				q.Set("last_name", "Doe")
+				return q
+			},
+			err: ErrInvalidHash,
+		},
+		{
+			name: "forged hash",
+			query: func() url.Values {
+				q := signedLogin(testNow, nil)
+				q.Set("hash", strings.Repeat("0", 64))
+				return q
+			},
+			err: ErrInvalidHash,
+		},
+		{
+			name: "missing hash",
+			query: func() url.Values {
+				q := signedLogin(testNow, nil)
+				q.Del("hash")
+				return q
+			},
+			err: ErrMissingData,
+		},
+		{
+			name: "missing id",
+			query: func() url.Values {
+				q := signedLogin(testNow, nil)
+				q.Del("id")
+				return q
+			},
+			err: ErrMissingData,
+		},
+		{
+			name: "missing auth_date",
+			query: func() url.Values {
+				q := signedLogin(testNow, nil)
+				q.Del("auth_date")
+				return q
+			},
+			err: ErrMissingData,
+		},
+		{
+			name:  "invalid auth_date",
+			query: func() url.Values { return signedLogin(testNow, map[string]string{"auth_date": "yesterday"}) },
+			err:   ErrInvalidAuthDate,
+		},
+		{
+			name:  "auth_date within clock skew",
+			query: func() url.Values { return signedLogin(testNow.Add(clockSkew), nil) },
+		},
+		{
+			name:  "auth_date beyond clock skew",
+			query: func() url.Values { return signedLogin(testNow.Add(clockSkew+time.Second), nil) },
+			err:   ErrInvalidAuthDate,
+		},
+		{
+			name:  "just before max age",
+			query: func() url.Values { return signedLogin(testNow.Add(-testMaxAge+time.Second), nil) },
+		},
+		{
+			name:  "exactly max age",
+			query: func() url.Values { return signedLogin(testNow.Add(-testMaxAge), nil) },
+			err:   ErrExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := newTestVerifier().Verify(tt.query())
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				if user != (User{}) {
+					t.Errorf("Verify() returned user %+v together with an error", user)
+				}
+				return
+			}
+			if user.ID != "42" || user.FirstName != "John" || user.Username != "john" {
+				t.Errorf("Verify() user = %+v", user)
+			}
+		})
+	}
+}
+
+func TestVerifyReplay(t *testing.T) {
+	v := newTestVerifier()
+	query := signedLogin(testNow, nil)
+	if _, err := v.Verify(query); err != nil {
+		t.Fatalf("first Verify() error = %v", err)
+	}
+	if _, err := v.Verify(query); !errors.Is(err, ErrReplayed) {
+		t.Errorf("second Verify() error = %v, want %v", err, ErrReplayed)
+	}
+
+	// Another login of the same user is still accepted
+	if _, err := v.Verify(signedLogin(testNow.Add(-time.Second), nil)); err != nil {
+		t.Errorf("Verify() of a new login error = %v", err)
+	}
+}
+
+func TestMarkUsedPrunesExpired(t *testing.T) {
+	v := newTestVerifier()
+	if !v.markUsed("old", testNow.Add(time.Minute), testNow) {
+		t.Fatal("markUsed() = false for a new hash")
+	}
+	if !v.markUsed("recent", testNow.Add(time.Hour), testNow) {
+		t.Fatal("markUsed() = false for a new hash")
+	}
+
+	// Once "old" has expired, it gets removed with the next login
+	later := testNow.Add(time.Minute)
+	if !v.markUsed("new", later.Add(time.Hour), later) {
+		t.Fatal("markUsed() = false for a new hash")
+	}
+	if _, ok := v.used["old"]; ok {
+		t.Error("expired hash has not been pruned")
+	}
+	if len(v.used) != 2 {
+		t.Errorf("%d hashes remembered, want 2", len(v.used))
+	}
+	if v.markUsed("recent", later.Add(time.Hour), later) {
+		t.Error("markUsed() = true for a hash which has not yet expired")
+	}
+}
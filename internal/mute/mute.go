@@ -0,0 +1,91 @@
+package mute
+
+import (
+	"errors"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"gorm.io/gorm"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidDuration = errors.New("invalid duration")
+
+// MaxDuration is the longest duration accepted by ParseDuration
+const MaxDuration = 365 * 24 * time.Hour
+
+// Store manages the mutes of the users' order notifications
+type Store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) *Store {
+	return &Store{db: db}
+}
+
+// Mute suppresses the notifications of the given product (or all products if empty) for the given duration
+func (s *Store) Mute(telegramID, productID string, d time.Duration) time.Time {
+	until := time.Now().Add(d)
+	// Replace an existing mute of the same scope
+	err := s.db.Where("telegram_id = ? AND product_id = ?", telegramID, productID).Delete(&database.Mute{}).Error
+	logger.LogErrorIfExists(err, telegramID)
+	err = s.db.Create(&database.Mute{TelegramID: telegramID, ProductID: productID, Until: until}).Error
+	logger.LogErrorIfExists(err, telegramID)
+	return until
+}
+
+// Unmute removes the mute of the given product. If productID is empty, all mutes of the user are removed.
+func (s *Store) Unmute(telegramID, productID string) {
+	query := s.db.Where("telegram_id = ?", telegramID)
+	if productID != "" {
+		query = query.Where("product_id = ?", productID)
+	}
+	logger.LogErrorIfExists(query.Delete(&database.Mute{}).Error, telegramID)
+}
+
+// IsMuted returns true if notifications of the given product are currently muted
+func (s *Store) IsMuted(telegramID, productID string) bool {
+	var count int64
+	err := s.db.Model(&database.Mute{}).
+		Where("telegram_id = ? AND product_id IN (?, '') AND until > ?", telegramID, productID, time.Now()).
+		Count(&count).Error
+	logger.LogErrorIfExists(err, telegramID)
+	return count > 0
+}
+
+// Active returns all mutes of the user, which have not yet expired
+func (s *Store) Active(telegramID string) []database.Mute {
+	var mutes []database.Mute
+	s.db.Where("telegram_id = ? AND until > ?", telegramID, time.Now()).Order("until").Find(&mutes)
+	return mutes
+}
+
+// DeleteExpired removes all expired mutes
+func (s *Store) DeleteExpired() {
+	logger.LogErrorIfExists(s.db.Where("until <= ?", time.Now()).Delete(&database.Mute{}).Error)
+}
+
+// ParseDuration parses durations like "30m", "2h", "1d" or "1w" up to MaxDuration
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) < 2 {
+		return 0, ErrInvalidDuration
+	}
+	units := map[byte]time.Duration{
+		'm': time.Minute,
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+	}
+	unit, ok := units[s[len(s)-1]]
+	if !ok {
+		return 0, ErrInvalidDuration
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	// Checked before multiplying, so that large numbers cannot overflow
+	if err != nil || n <= 0 || int64(n) > int64(MaxDuration/unit) {
+		return 0, ErrInvalidDuration
+	}
+	return time.Duration(n) * unit, nil
+}
@@ -0,0 +1,35 @@
+package mute
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		err  error
+	}{
+		{in: "30m", want: 30 * time.Minute},
+		{in: " 2H ", want: 2 * time.Hour},
+		{in: "1d", want: 24 * time.Hour},
+		{in: "1w", want: 7 * 24 * time.Hour},
+		{in: "365d", want: MaxDuration},
+		{in: "366d", err: ErrInvalidDuration},
+		{in: "53w", err: ErrInvalidDuration},
+		{in: "9223372036854775807m", err: ErrInvalidDuration},
+		{in: "0h", err: ErrInvalidDuration},
+		{in: "-1h", err: ErrInvalidDuration},
+		{in: "1y", err: ErrInvalidDuration},
+		{in: "h", err: ErrInvalidDuration},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseDuration(tt.in)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("ParseDuration(%q) = %v, %v, want %v, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
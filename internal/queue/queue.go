@@ -0,0 +1,169 @@
+package queue
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy defines what happens when an item is pushed onto a full queue
+type OverflowPolicy int
+
+const (
+	// DropNewest discards the item which should be pushed onto the full queue
+	DropNewest OverflowPolicy = iota
+	// DropOldest discards the oldest queued item in order to make room for the new one
+	DropOldest
+)
+
+// String returns the name of the overflow policy
+func (p OverflowPolicy) String() string {
+	switch p {
+	case DropNewest:
+		return "drop_newest"
+	case DropOldest:
+		return "drop_oldest"
+	default:
+		return "unknown"
+	}
+}
+
+// Stats holds the metrics of a queue
+type Stats struct {
+	Name     string `json:"name"`
+	Policy   string `json:"policy"`
+	Capacity int    `json:"capacity"`
+	Depth    int    `json:"depth"`
+	MaxDepth int    `json:"max_depth"`
+	Enqueued uint64 `json:"enqueued"`
+	Dequeued uint64 `json:"dequeued"`
+	Dropped  uint64 `json:"dropped"`
+}
+
+// Queue is a bounded FIFO queue which never blocks the producer.
+// Whenever the queue is full, items are dropped according to the OverflowPolicy.
+type Queue struct {
+	name     string
+	policy   OverflowPolicy
+	items    chan interface{}
+	mu       sync.Mutex // Serializes producers, so that DropOldest never blocks
+	closed   bool
+	maxDepth int64
+	enqueued uint64
+	dequeued uint64
+	dropped  uint64
+	onDrop   func(item interface{})
+}
+
+// New creates a new queue with the given name, capacity and overflow policy
+func New(name string, capacity int, policy OverflowPolicy) *Queue {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &Queue{
+		name:   name,
+		policy: policy,
+		items:  make(chan interface{}, capacity),
+	}
+}
+
+// OnDrop registers a callback which gets called for every dropped item
+func (q *Queue) OnDrop(fn func(item interface{})) *Queue {
+	q.onDrop = fn
+	return q
+}
+
+// Push adds an item to the queue without blocking.
+// It returns false if an item (either the given or the oldest one) has been dropped.
+// Items pushed onto a closed queue are always dropped.
+func (q *Queue) Push(item interface{}) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		q.drop(item)
+		return false
+	}
+
+	select {
+	case q.items <- item:
+		q.pushed()
+		return true
+	default:
+	}
+
+	if q.policy == DropNewest {
+		q.drop(item)
+		return false
+	}
+
+	// Make room for the new item by discarding the oldest one
+	select {
+	case oldest := <-q.items:
+		q.drop(oldest)
+	default:
+	}
+	select {
+	case q.items <- item:
+		q.pushed()
+	default:
+		q.drop(item)
+	}
+
+	return false
+}
+
+// Close closes the queue. The consumer still receives all queued items, before the channel returned by C gets closed.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.items)
+	}
+}
+
+// C returns the channel from which the consumer receives the queued items.
+// Use Done to account for each received item.
+func (q *Queue) C() <-chan interface{} {
+	return q.items
+}
+
+// Done marks a received item as dequeued
+func (q *Queue) Done() {
+	atomic.AddUint64(&q.dequeued, 1)
+}
+
+// Len returns the current number of queued items
+func (q *Queue) Len() int {
+	return len(q.items)
+}
+
+// Stats returns a snapshot of the queue metrics
+func (q *Queue) Stats() Stats {
+	return Stats{
+		Name:     q.name,
+		Policy:   q.policy.String(),
+		Capacity: cap(q.items),
+		Depth:    len(q.items),
+		MaxDepth: int(atomic.LoadInt64(&q.maxDepth)),
+		Enqueued: atomic.LoadUint64(&q.enqueued),
+		Dequeued: atomic.LoadUint64(&q.dequeued),
+		Dropped:  atomic.LoadUint64(&q.dropped),
+	}
+}
+
+func (q *Queue) pushed() {
+	atomic.AddUint64(&q.enqueued, 1)
+	depth := int64(len(q.items))
+	if depth > atomic.LoadInt64(&q.maxDepth) {
+		atomic.StoreInt64(&q.maxDepth, depth)
+	}
+}
+
+func (q *Queue) drop(item interface{}) {
+	atomic.AddUint64(&q.dropped, 1)
+	if q.onDrop != nil {
+		q.onDrop(item)
+	}
+}
@@ -0,0 +1,145 @@
+package queue
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// drain receives all queued items of a closed queue
+func drain(q *Queue) []interface{} {
+	var items []interface{}
+	for item := range q.C() {
+		q.Done()
+		items = append(items, item)
+	}
+	return items
+}
+
+func TestPushOverflow(t *testing.T) {
+	tests := []struct {
+		policy    OverflowPolicy
+		accepted  []bool
+		remaining []interface{}
+		dropped   []interface{}
+		stats     Stats
+	}{
+		{
+			policy:    DropOldest,
+			accepted:  []bool{true, true, false, false},
+			remaining: []interface{}{3, 4},
+			dropped:   []interface{}{1, 2},
+			stats:     Stats{Name: "q", Policy: "drop_oldest", Capacity: 2, Depth: 2, MaxDepth: 2, Enqueued: 4, Dropped: 2},
+		},
+		{
+			policy:    DropNewest,
+			accepted:  []bool{true, true, false, false},
+			remaining: []interface{}{1, 2},
+			dropped:   []interface{}{3, 4},
+			stats:     Stats{Name: "q", Policy: "drop_newest", Capacity: 2, Depth: 2, MaxDepth: 2, Enqueued: 2, Dropped: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			var dropped []interface{}
+			q := New("q", 2, tt.policy).OnDrop(func(item interface{}) { dropped = append(dropped, item) })
+			for i, want := range tt.accepted {
+				if got := q.Push(i + 1); got != want {
+					t.Errorf("Push(%d) = %v, want %v", i+1, got, want)
+				}
+			}
+			if got := q.Stats(); got != tt.stats {
+				t.Errorf("Stats() = %+v, want %+v", got, tt.stats)
+			}
+			if !reflect.DeepEqual(dropped, tt.dropped) {
+				t.Errorf("dropped = %v, want %v", dropped, tt.dropped)
+			}
+
+			q.Close()
+			if got := drain(q); !reflect.DeepEqual(got, tt.remaining) {
+				t.Errorf("remaining = %v, want %v", got, tt.remaining)
+			}
+			if got := q.Stats(); got.Dequeued != uint64(len(tt.remaining)) || got.Depth != 0 || got.MaxDepth != 2 {
+				t.Errorf("Stats() after drain = %+v", got)
+			}
+		})
+	}
+}
+
+func TestPushAfterClose(t *testing.T) {
+	var dropped []interface{}
+	q := New("q", 4, DropOldest).OnDrop(func(item interface{}) { dropped = append(dropped, item) })
+	q.Push(1)
+	q.Close()
+	q.Close() // Closing twice must not panic
+
+	if q.Push(2) {
+		t.Error("Push after Close = true, want false")
+	}
+	if !reflect.DeepEqual(dropped, []interface{}{2}) {
+		t.Errorf("dropped = %v, want [2]", dropped)
+	}
+	if got := q.Stats(); got.Enqueued != 1 || got.Dropped != 1 {
+		t.Errorf("Stats() = %+v, want 1 enqueued and 1 dropped", got)
+	}
+}
+
+func TestDrainAfterClose(t *testing.T) {
+	q := New("q", 8, DropNewest)
+	for i := 1; i <= 5; i++ {
+		q.Push(i)
+	}
+	q.Close()
+
+	if got, want := drain(q), []interface{}{1, 2, 3, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("drained = %v, want %v", got, want)
+	}
+	if _, ok := <-q.C(); ok {
+		t.Error("channel still open after draining a closed queue")
+	}
+	if got := q.Stats(); got.Enqueued != 5 || got.Dequeued != 5 || got.Depth != 0 {
+		t.Errorf("Stats() = %+v", got)
+	}
+}
+
+func TestConcurrentPush(t *testing.T) {
+	const (
+		producers = 8
+		items     = 500
+		capacity  = 16
+	)
+	for _, policy := range []OverflowPolicy{DropOldest, DropNewest} {
+		t.Run(policy.String(), func(t *testing.T) {
+			q := New("q", capacity, policy)
+			var wg sync.WaitGroup
+			for p := 0; p < producers; p++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < items; i++ {
+						q.Push(i)
+					}
+				}()
+			}
+			wg.Wait()
+
+			// Without a consumer every push either ends up in the queue or gets dropped
+			s := q.Stats()
+			total := uint64(producers * items)
+			if s.Depth != capacity || s.MaxDepth != capacity {
+				t.Errorf("Depth = %d, MaxDepth = %d, want %d", s.Depth, s.MaxDepth, capacity)
+			}
+			switch policy {
+			case DropOldest:
+				if s.Enqueued != total || s.Dropped != total-capacity {
+					t.Errorf("Enqueued = %d, Dropped = %d, want %d and %d", s.Enqueued, s.Dropped, total, total-capacity)
+				}
+			case DropNewest:
+				if s.Enqueued != capacity || s.Dropped != total-capacity {
+					t.Errorf("Enqueued = %d, Dropped = %d, want %d and %d", s.Enqueued, s.Dropped, capacity, total-capacity)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenBucket is a rate limiter which allows bursts of up to burst events and refills with rate tokens per second.
+// It is safe for concurrent use.
+type TokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+// New creates a new token bucket, which is initially full
+func New(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until a token is available or ctx is done. In the latter case ctx.Err() is returned.
+// Waiting callers are served in the order in which they called Wait.
+func (tb *TokenBucket) Wait(ctx context.Context) error {
+	delay := tb.reserve()
+	if delay <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		tb.cancel()
+		return ctx.Err()
+	}
+}
+
+// Allow takes a token if one is available without waiting
+func (tb *TokenBucket) Allow() bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill(time.Now())
+	if tb.tokens < 1 {
+		return false
+	}
+	tb.tokens--
+	return true
+}
+
+// reserve takes a token, which might not yet exist, and returns the duration until it will be available
+func (tb *TokenBucket) reserve() time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := time.Now()
+	tb.refill(now)
+	tb.tokens--
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// cancel gives back a reserved token, which has not been used
+func (tb *TokenBucket) cancel() {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	tb.refill(time.Now())
+	tb.tokens++
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+}
+
+// refill adds the tokens which have been generated since the last refill
+func (tb *TokenBucket) refill(now time.Time) {
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+}
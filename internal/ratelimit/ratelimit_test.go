@@ -0,0 +1,145 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// available returns the current number of tokens of the bucket
+func (tb *TokenBucket) available() float64 {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	return tb.tokens
+}
+
+// rewind moves the last refill of the bucket into the past, as if d has passed
+func (tb *TokenBucket) rewind(d time.Duration) {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.last = tb.last.Add(-d)
+}
+
+func TestBurst(t *testing.T) {
+	tb := New(0.001, 3)
+	for i := 0; i < 3; i++ {
+		if !tb.Allow() {
+			t.Fatalf("Allow() #%d = false, want true within the burst", i+1)
+		}
+	}
+	if tb.Allow() {
+		t.Error("Allow() = true after the burst has been used up")
+	}
+}
+
+func TestRefill(t *testing.T) {
+	tb := New(2, 4)
+	for tb.Allow() {
+	}
+
+	// 2 tokens per second => 1.5s refill 3 tokens
+	tb.rewind(1500 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if !tb.Allow() {
+			t.Fatalf("Allow() #%d = false after refilling 3 tokens", i+1)
+		}
+	}
+	if tb.Allow() {
+		t.Error("Allow() = true, although only 3 tokens have been refilled")
+	}
+
+	// The bucket never holds more than burst tokens
+	tb.rewind(time.Hour)
+	tb.mu.Lock()
+	tb.refill(time.Now())
+	tb.mu.Unlock()
+	if got := tb.available(); got != 4 {
+		t.Errorf("tokens = %v after a long pause, want the burst of 4", got)
+	}
+}
+
+func TestReserveFIFO(t *testing.T) {
+	tb := New(10, 1)
+	if d := tb.reserve(); d != 0 {
+		t.Fatalf("first reserve() = %v, want 0", d)
+	}
+
+	// Each further reservation has to wait for one more token (100ms each)
+	var last time.Duration
+	for i := 1; i <= 3; i++ {
+		d := tb.reserve()
+		want := time.Duration(i) * 100 * time.Millisecond
+		if d <= last || d > want || d < want-10*time.Millisecond {
+			t.Errorf("reserve() #%d = %v, want about %v", i, d, want)
+		}
+		last = d
+	}
+}
+
+func TestWaitOrder(t *testing.T) {
+	tb := New(20, 1)
+	tb.Allow()
+
+	var (
+		mu    sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+	for i := 1; i <= 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := tb.Wait(context.Background()); err != nil {
+				t.Errorf("Wait() = %v", err)
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+		}(i)
+		// Start the next waiter only after this one has reserved its token
+		for tb.available() > float64(-i)+0.5 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	wg.Wait()
+
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(order, want) {
+		t.Errorf("waiters served in order %v, want %v", order, want)
+	}
+}
+
+func TestWaitCancel(t *testing.T) {
+	tb := New(1, 1)
+	tb.Allow()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := tb.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Wait() returned after %v, not when ctx was done", elapsed)
+	}
+
+	// The reserved token has been given back, so the next caller does not wait for it
+	if got := tb.available(); got < -0.1 {
+		t.Errorf("tokens = %v after cancellation, want the reservation to be returned", got)
+	}
+	if d := tb.reserve(); d > time.Second {
+		t.Errorf("reserve() = %v after cancellation, want at most 1s", d)
+	}
+}
+
+func TestWaitAvailable(t *testing.T) {
+	tb := New(1, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// An available token is taken even if ctx is already done
+	if err := tb.Wait(ctx); err != nil {
+		t.Errorf("Wait() = %v with an available token, want nil", err)
+	}
+}
@@ -0,0 +1,146 @@
+package role
+
+import (
+	"errors"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"gorm.io/gorm"
+	"sort"
+	"strings"
+)
+
+// Role defines the permissions of a telegram user
+type Role string
+
+const (
+	// Owner manages the roles and has all permissions
+	Owner Role = "owner"
+	// Admin manages the users and sends broadcasts
+	Admin Role = "admin"
+	// Support inspects the state of the instance and receives the operational notifications
+	Support Role = "support"
+	// User is the default role of everybody without an elevated role
+	User Role = "user"
+)
+
+var (
+	ErrInvalidRole    = errors.New("invalid role")
+	ErrBootstrapOwner = errors.New("the role of the bootstrap owner cannot be changed")
+)
+
+// All contains all roles ordered by increasing permissions
+var All = []Role{User, Support, Admin, Owner}
+
+// Parse converts the name of a role into a Role
+func Parse(name string) (Role, error) {
+	r := Role(strings.ToLower(strings.TrimSpace(name)))
+	if r.rank() < 0 {
+		return "", ErrInvalidRole
+	}
+	return r, nil
+}
+
+// AtLeast returns true if the role has the same or more permissions than min
+func (r Role) AtLeast(min Role) bool {
+	return r.rank() >= min.rank()
+}
+
+// Outranks returns true if the role has more permissions than other
+func (r Role) Outranks(other Role) bool {
+	return r.rank() > other.rank()
+}
+
+func (r Role) rank() int {
+	for i, role := range All {
+		if role == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// Member is a telegram user with an elevated role
+type Member struct {
+	TelegramID string
+	Role       Role
+	GrantedBy  string
+}
+
+// Store manages the roles of the telegram users.
+// The bootstrap owner (TELEGRAM_ADMIN_CHAT_ID) is always an owner, even without a database entry.
+type Store struct {
+	db      *gorm.DB
+	ownerID string
+}
+
+func NewStore(db *gorm.DB, ownerID string) *Store {
+	return &Store{db: db, ownerID: ownerID}
+}
+
+// Get returns the role of the given user
+func (s *Store) Get(telegramID string) Role {
+	if telegramID == "" {
+		return User
+	}
+	if telegramID == s.ownerID {
+		return Owner
+	}
+	var userRole database.UserRole
+	err := s.db.Where("telegram_id = ?", telegramID).Limit(1).Find(&userRole).Error
+	logger.LogErrorIfExists(err, telegramID)
+	if r, err := Parse(userRole.Role); err == nil {
+		return r
+	}
+	return User
+}
+
+// Set grants the given role to a user. Setting User removes the elevated role.
+func (s *Store) Set(telegramID string, r Role, grantedBy string) error {
+	if r.rank() < 0 {
+		return ErrInvalidRole
+	}
+	if telegramID == s.ownerID {
+		return ErrBootstrapOwner
+	}
+	if r == User {
+		return s.db.Where("telegram_id = ?", telegramID).Delete(&database.UserRole{}).Error
+	}
+	return s.db.Save(&database.UserRole{TelegramID: telegramID, Role: string(r), GrantedBy: grantedBy}).Error
+}
+
+// Members returns all users with at least the given role, ordered by decreasing permissions
+func (s *Store) Members(min Role) []Member {
+	var members []Member
+	if s.ownerID != "" {
+		members = append(members, Member{TelegramID: s.ownerID, Role: Owner})
+	}
+
+	var userRoles []database.UserRole
+	err := s.db.Where("telegram_id <> ?", s.ownerID).Order("telegram_id").Find(&userRoles).Error
+	logger.LogErrorIfExists(err)
+	for _, ur := range userRoles {
+		if r, err := Parse(ur.Role); err == nil {
+			members = append(members, Member{TelegramID: ur.TelegramID, Role: r, GrantedBy: ur.GrantedBy})
+		}
+	}
+
+	var filtered []Member
+	for _, m := range members {
+		if m.Role.AtLeast(min) {
+			filtered = append(filtered, m)
+		}
+	}
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return filtered[i].Role.rank() > filtered[j].Role.rank()
+	})
+	return filtered
+}
+
+// IDs returns the telegram IDs of all users with at least the given role
+func (s *Store) IDs(min Role) []string {
+	var ids []string
+	for _, m := range s.Members(min) {
+		ids = append(ids, m.TelegramID)
+	}
+	return ids
+}
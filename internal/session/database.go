@@ -0,0 +1,120 @@
+package session
+
+import (
+	"encoding/base32"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"gorm.io/gorm"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DatabaseStore stores the sessions in the database. The cookie only contains the signed and encrypted session ID.
+// The session values are encrypted with the same keys before they are stored.
+type DatabaseStore struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options // Default options of new sessions
+	db      *gorm.DB
+}
+
+// NewDatabaseStore creates a database store, see sessions.NewCookieStore for the key pairs
+func NewDatabaseStore(db *gorm.DB, options *sessions.Options, keyPairs ...[]byte) *DatabaseStore {
+	s := &DatabaseStore{
+		Codecs:  securecookie.CodecsFromPairs(keyPairs...),
+		Options: options,
+		db:      db,
+	}
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(options.MaxAge)
+			// The size of the values is not restricted by the cookie size
+			sc.MaxLength(0)
+		}
+	}
+	return s
+}
+
+// Get returns the session of the request, which is cached within the request registry
+func (s *DatabaseStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns the session of the request. If it does not exist or has expired, a new session is returned.
+func (s *DatabaseStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	options := *s.Options
+	session.Options = &options
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	if err = securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...); err != nil {
+		session.ID = ""
+		return session, err
+	}
+	found, err := s.load(session)
+	if !found {
+		// Never reuse the ID of an unknown or expired session
+		session.ID = ""
+		return session, err
+	}
+	session.IsNew = false
+	return session, nil
+}
+
+// Save stores the session and sets the cookie. If MaxAge is <= 0, the session gets deleted.
+func (s *DatabaseStore) Save(_ *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge <= 0 {
+		if session.ID != "" {
+			if err := s.db.Delete(&database.Session{}, "id = ?", session.ID).Error; err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+	}
+	data, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	err = s.db.Save(&database.Session{
+		ID:        session.ID,
+		Data:      data,
+		ExpiresAt: time.Now().Add(time.Duration(session.Options.MaxAge) * time.Second),
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	cookie, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), cookie, session.Options))
+	return nil
+}
+
+// DeleteExpired removes all expired sessions
+func (s *DatabaseStore) DeleteExpired() {
+	logger.LogErrorIfExists(s.db.Where("expires_at <= ?", time.Now()).Delete(&database.Session{}).Error)
+}
+
+// load reads the values of the session. It returns false if the session does not exist or has expired.
+func (s *DatabaseStore) load(session *sessions.Session) (bool, error) {
+	var row database.Session
+	err := s.db.Where("id = ? AND expires_at > ?", session.ID, time.Now()).Limit(1).Find(&row).Error
+	if err != nil || row.ID == "" {
+		return false, err
+	}
+	err = securecookie.DecodeMulti(session.Name(), row.Data, &session.Values, s.Codecs...)
+	return err == nil, err
+}
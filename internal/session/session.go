@@ -0,0 +1,150 @@
+package session
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"gorm.io/gorm"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Backends of the session store
+const (
+	StoreCookie   = "cookie"   // The session values are stored within the encrypted cookie
+	StoreDatabase = "database" // Only the session ID is stored within the cookie
+)
+
+const defaultMaxAge = time.Hour
+
+var (
+	ErrInvalidKeys     = errors.New("SESSION_KEYS must be a comma separated list of <auth key>:<encryption key> pairs")
+	ErrInvalidKeySize  = errors.New("session auth keys must have at least 32 bytes and encryption keys 16, 24 or 32 bytes")
+	ErrInvalidStore    = errors.New("SESSION_STORE must be either cookie or database")
+	ErrInvalidSameSite = errors.New("SESSION_SAME_SITE must be either lax, strict or none")
+)
+
+// Config of the session store
+type Config struct {
+	// KeyPairs contains the auth and encryption keys alternately. The first pair is used for
+	// encoding new sessions, all pairs are used for decoding, which allows rotating the keys.
+	KeyPairs [][]byte
+	Store    string
+	Options  sessions.Options
+}
+
+// ConfigFromEnv reads the session config from the following env vars:
+//
+//	SESSION_KEYS       comma separated list of base64 encoded <auth key>:<encryption key> pairs, newest first
+//	SESSION_STORE      cookie (default) or database
+//	SESSION_MAX_AGE    lifetime of a session, e.g. 1h (default)
+//	SESSION_SECURE     send the cookie only via https (default true)
+//	SESSION_DOMAIN     domain of the cookie (optional)
+//	SESSION_SAME_SITE  lax (default), strict or none
+//
+// If SESSION_KEYS is not set, random keys are generated, which invalidates all sessions on restart.
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Store: StoreCookie,
+		Options: sessions.Options{
+			Path:     "/",
+			Domain:   os.Getenv("SESSION_DOMAIN"),
+			MaxAge:   int(defaultMaxAge.Seconds()),
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: http.SameSiteLaxMode,
+		},
+	}
+
+	var err error
+	if keys := os.Getenv("SESSION_KEYS"); keys != "" {
+		if cfg.KeyPairs, err = ParseKeyPairs(keys); err != nil {
+			return cfg, err
+		}
+	} else {
+		logger.LogWarn("SESSION_KEYS is not set => using random keys, all users get logged out on restart")
+		cfg.KeyPairs = [][]byte{securecookie.GenerateRandomKey(64), securecookie.GenerateRandomKey(32)}
+	}
+
+	switch store := strings.ToLower(os.Getenv("SESSION_STORE")); store {
+	case "", StoreCookie:
+	case StoreDatabase:
+		cfg.Store = StoreDatabase
+	default:
+		return cfg, ErrInvalidStore
+	}
+
+	if v := os.Getenv("SESSION_MAX_AGE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid SESSION_MAX_AGE %q", v)
+		}
+		cfg.Options.MaxAge = int(d.Seconds())
+	}
+
+	if v := os.Getenv("SESSION_SECURE"); v != "" {
+		if cfg.Options.Secure, err = strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf("invalid SESSION_SECURE %q", v)
+		}
+	}
+
+	switch strings.ToLower(os.Getenv("SESSION_SAME_SITE")) {
+	case "", "lax":
+	case "strict":
+		cfg.Options.SameSite = http.SameSiteStrictMode
+	case "none":
+		cfg.Options.SameSite = http.SameSiteNoneMode
+	default:
+		return cfg, ErrInvalidSameSite
+	}
+
+	return cfg, nil
+}
+
+// ParseKeyPairs parses a comma separated list of base64 encoded <auth key>:<encryption key> pairs
+func ParseKeyPairs(s string) ([][]byte, error) {
+	var keyPairs [][]byte
+	for _, pair := range strings.Split(s, ",") {
+		keys := strings.Split(strings.TrimSpace(pair), ":")
+		if len(keys) != 2 {
+			return nil, ErrInvalidKeys
+		}
+		authKey, err := base64.StdEncoding.DecodeString(keys[0])
+		if err != nil {
+			return nil, ErrInvalidKeys
+		}
+		encryptionKey, err := base64.StdEncoding.DecodeString(keys[1])
+		if err != nil {
+			return nil, ErrInvalidKeys
+		}
+		if len(authKey) < 32 {
+			return nil, ErrInvalidKeySize
+		}
+		switch len(encryptionKey) {
+		case 16, 24, 32:
+		default:
+			return nil, ErrInvalidKeySize
+		}
+		keyPairs = append(keyPairs, authKey, encryptionKey)
+	}
+	return keyPairs, nil
+}
+
+// NewStore creates the session store of the config. The database is only used by the database store.
+func NewStore(cfg Config, db *gorm.DB) sessions.Store {
+	options := cfg.Options
+	if cfg.Store == StoreDatabase {
+		return NewDatabaseStore(db, &options, cfg.KeyPairs...)
+	}
+
+	store := sessions.NewCookieStore(cfg.KeyPairs...)
+	store.Options = &options
+	store.MaxAge(options.MaxAge)
+	return store
+}
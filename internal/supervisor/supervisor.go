@@ -0,0 +1,238 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"github.com/sknr/go-coinbasepro-notifier/internal/ratelimit"
+	"github.com/sknr/go-coinbasepro-notifier/internal/role"
+	"github.com/sknr/go-coinbasepro-notifier/internal/telegram"
+	"github.com/sknr/go-coinbasepro-notifier/internal/updater"
+	"github.com/sknr/go-coinbasepro-notifier/internal/watcher"
+	"gorm.io/gorm"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// maxAuthFailures is the number of consecutive authentication failures after which
+	// the credentials are considered permanently invalid and the watcher gets paused
+	maxAuthFailures = 3
+
+	// Limits for websocket dials and subscriptions of all watchers, in order to not hit the coinbase pro api limits
+	dialRate  = 1 // per second
+	dialBurst = 3
+)
+
+// Supervisor manages the watchers of all users and keeps track of their health
+type Supervisor struct {
+	db       *gorm.DB
+	updater  *updater.Updater
+	limiter  *ratelimit.TokenBucket
+	watchers map[string]*entry
+	closed   bool // No more watchers are started after Shutdown
+	mu       sync.RWMutex
+	notify   func(settings database.UserSettings) // Informs about a paused watcher, replaced within tests
+	onPause  func(settings database.UserSettings)
+}
+
+type entry struct {
+	watcher  *watcher.CoinbaseProWatcher
+	settings database.UserSettings // PausedReason is set while the watcher is paused
+}
+
+// WatcherStatus is the status of a single supervised watcher
+type WatcherStatus struct {
+	TelegramID  string `json:"telegram_id"`
+	FirstName   string `json:"first_name"`
+	Paused      bool   `json:"paused"`
+	PauseReason string `json:"pause_reason"`
+	watcher.Status
+}
+
+func New(updater *updater.Updater, db *gorm.DB) *Supervisor {
+	return &Supervisor{
+		db:       db,
+		updater:  updater,
+		limiter:  ratelimit.New(dialRate, dialBurst),
+		watchers: make(map[string]*entry),
+		notify:   notifyPause,
+	}
+}
+
+// OnPause registers a function, which gets called after a watcher has been paused.
+// It must be registered before the first watcher is started.
+func (s *Supervisor) OnPause(fn func(settings database.UserSettings)) {
+	s.onPause = fn
+}
+
+// Start (re)starts the watcher for the given user settings. An already running or paused watcher gets replaced.
+// The new watcher is not started, but remains paused, if the settings contain a pause reason.
+// After Shutdown, Start has no effect.
+func (s *Supervisor) Start(settings database.UserSettings) {
+	s.start(settings, true)
+}
+
+// StartAll starts the watchers for the given user settings without blocking.
+// Users whose watcher has already been started in the meantime are skipped, paused users are only registered.
+// The actual connections are established one after the other as permitted by the shared limiter.
+func (s *Supervisor) StartAll(settings []database.UserSettings) {
+	for _, us := range settings {
+		s.start(us, false)
+	}
+}
+
+// start creates and starts a new watcher. An existing watcher is only replaced if replace is true.
+func (s *Supervisor) start(settings database.UserSettings, replace bool) {
+	w := watcher.New(settings, s.updater, s.db, s.limiter)
+	w.OnStateChange(func(status watcher.Status) {
+		s.stateChanged(settings.TelegramID, w, status)
+	})
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		logger.LogWarnf("[%s] Watcher not started, because the supervisor has been shut down", settings.TelegramID)
+		return
+	}
+	old := s.watchers[settings.TelegramID]
+	if old != nil && !replace {
+		s.mu.Unlock()
+		return
+	}
+	s.watchers[settings.TelegramID] = &entry{watcher: w, settings: settings}
+	s.mu.Unlock()
+
+	if old != nil {
+		old.watcher.Stop()
+	}
+	// Rejected credentials are not retried until they get updated
+	if settings.PausedReason != "" {
+		logger.LogInfof("[%s] Watcher remains paused: %s", settings.TelegramID, settings.PausedReason)
+		return
+	}
+	// Start watching for user related order updates
+	w.Start()
+}
+
+// Stop stops the watcher of the given user and removes it from the supervisor
+func (s *Supervisor) Stop(telegramID string) {
+	s.mu.Lock()
+	e := s.watchers[telegramID]
+	delete(s.watchers, telegramID)
+	s.mu.Unlock()
+
+	if e != nil {
+		e.watcher.Stop()
+	}
+}
+
+// Shutdown stops all watchers in parallel and waits until their queued notifications have been delivered.
+// If ctx expires before, the remaining notifications are discarded and ctx.Err() is returned.
+// Afterwards, no more watchers can be started.
+func (s *Supervisor) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	entries := s.watchers
+	s.watchers = make(map[string]*entry)
+	s.mu.Unlock()
+
+	var (
+		wg     sync.WaitGroup
+		failed int32
+	)
+	for _, e := range entries {
+		wg.Add(1)
+		go func(e *entry) {
+			defer wg.Done()
+			if err := e.watcher.Shutdown(ctx); err != nil {
+				logger.LogWarnf("[%s] Watcher could not be drained: %v", e.settings.TelegramID, err)
+				atomic.StoreInt32(&failed, 1)
+			}
+		}(e)
+	}
+	wg.Wait()
+
+	if atomic.LoadInt32(&failed) != 0 {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// Status returns the status of the watcher of the given user
+func (s *Supervisor) Status(telegramID string) (WatcherStatus, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.watchers[telegramID]
+	if !ok {
+		return WatcherStatus{}, false
+	}
+	return e.status(), true
+}
+
+// Statuses returns the status of all supervised watchers sorted by telegram ID
+func (s *Supervisor) Statuses() []WatcherStatus {
+	s.mu.RLock()
+	statuses := make([]WatcherStatus, 0, len(s.watchers))
+	for _, e := range s.watchers {
+		statuses = append(statuses, e.status())
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].TelegramID < statuses[j].TelegramID
+	})
+	return statuses
+}
+
+// stateChanged gets called by the watcher on every state change
+func (s *Supervisor) stateChanged(telegramID string, w *watcher.CoinbaseProWatcher, status watcher.Status) {
+	logger.LogInfof("[%s] Watcher state changed to %q", telegramID, status.State)
+	if status.State == watcher.StateAuthFailed && status.AuthFailures >= maxAuthFailures {
+		// The callback runs within the watcher, which cannot wait for its own termination
+		go s.pause(telegramID, w, fmt.Sprintf("%d consecutive authentication failures", status.AuthFailures))
+	}
+}
+
+// pause stops the given watcher but keeps it within the supervisor, so that its state remains visible.
+// The pause is stored within the user settings, so that the watcher is not resumed on restart.
+func (s *Supervisor) pause(telegramID string, w *watcher.CoinbaseProWatcher, reason string) {
+	s.mu.Lock()
+	e := s.watchers[telegramID]
+	// The watcher might have been replaced or removed in the meantime
+	if e == nil || e.watcher != w || e.settings.PausedReason != "" {
+		s.mu.Unlock()
+		return
+	}
+	e.settings.PausedReason = reason
+	settings := e.settings
+	s.mu.Unlock()
+
+	err := s.db.Model(&database.UserSettings{}).Where("telegram_id = ?", telegramID).Update("paused_reason", reason).Error
+	logger.LogErrorIfExists(err, telegramID)
+	w.Stop()
+	logger.LogWarnf("[%s] Watcher paused: %s", telegramID, reason)
+	if s.onPause != nil {
+		s.onPause(settings)
+	}
+	s.notify(settings)
+}
+
+// notifyPause informs the user and the support team about a paused watcher
+func notifyPause(settings database.UserSettings) {
+	telegram.SendPushMessage(settings.TelegramID, "Your notifications have been paused, because Coinbase Pro keeps rejecting your API-Key. Please update your API-Settings to resume.")
+	telegram.SendRolePushMessage(role.Support, fmt.Sprintf("Watcher of user %s (%s) has been paused: %s", settings.FirstName, settings.TelegramID, settings.PausedReason))
+}
+
+// status returns the status of the entry. The caller must hold the supervisor's lock.
+func (e *entry) status() WatcherStatus {
+	return WatcherStatus{
+		TelegramID:  e.settings.TelegramID,
+		FirstName:   e.settings.FirstName,
+		Paused:      e.settings.PausedReason != "",
+		PauseReason: e.settings.PausedReason,
+		Status:      e.watcher.Status(),
+	}
+}
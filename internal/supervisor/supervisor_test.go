@@ -0,0 +1,136 @@
+package supervisor
+
+import (
+	"context"
+	"github.com/foxever/sqlite"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"github.com/sknr/go-coinbasepro-notifier/internal/updater"
+	"github.com/sknr/go-coinbasepro-notifier/internal/watcher"
+	"gorm.io/gorm"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestDB creates a fresh database containing the user settings table
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.AutoMigrate(&database.UserSettings{}); err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Serialize the concurrent writes of the pauses
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	return db
+}
+
+func TestStartAllKeepsPausedWatchers(t *testing.T) {
+	s := New(&updater.Updater{}, nil)
+	s.StartAll([]database.UserSettings{{TelegramID: "1", FirstName: "John", PausedReason: "3 consecutive authentication failures"}})
+	defer s.Stop("1")
+
+	status, ok := s.Status("1")
+	if !ok {
+		t.Fatal("paused watcher is not registered")
+	}
+	if !status.Paused || status.PauseReason != "3 consecutive authentication failures" {
+		t.Errorf("status = %+v, want paused with the stored reason", status)
+	}
+	if status.State != watcher.StateStopped {
+		t.Errorf("state = %s, want the paused watcher not to be started", status.State)
+	}
+}
+
+func TestStartAfterShutdown(t *testing.T) {
+	s := New(&updater.Updater{}, nil)
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	s.Start(database.UserSettings{TelegramID: "1", PausedReason: "paused, so that nothing gets connected"})
+	if _, ok := s.Status("1"); ok {
+		t.Error("watcher registered after Shutdown")
+	}
+}
+
+func TestPauseOnAuthFailures(t *testing.T) {
+	const users = 20
+	db := newTestDB(t)
+	s := New(&updater.Updater{}, db)
+	notified := make(chan database.UserSettings, users)
+	s.notify = func(settings database.UserSettings) { notified <- settings }
+	var recorded int32
+	s.OnPause(func(database.UserSettings) { atomic.AddInt32(&recorded, 1) })
+
+	// The watchers are not started, as only the reaction on their state changes is of interest
+	watchers := make(map[string]*watcher.CoinbaseProWatcher)
+	for i := 1; i <= users; i++ {
+		settings := database.UserSettings{TelegramID: strconv.Itoa(i), FirstName: "John", Active: true}
+		if err := db.Create(&settings).Error; err != nil {
+			t.Fatal(err)
+		}
+		w := watcher.New(settings, s.updater, db, s.limiter)
+		watchers[settings.TelegramID] = w
+		s.watchers[settings.TelegramID] = &entry{watcher: w, settings: settings}
+		defer s.Stop(settings.TelegramID)
+	}
+
+	// Query the status concurrently to the pauses, so that the race detector notices unsynchronized access
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			for id := range watchers {
+				select {
+				case <-stop:
+					return
+				default:
+					s.Status(id)
+				}
+			}
+		}
+	}()
+
+	for id, w := range watchers {
+		s.stateChanged(id, w, watcher.Status{State: watcher.StateAuthFailed, AuthFailures: maxAuthFailures - 1})
+		s.stateChanged(id, w, watcher.Status{State: watcher.StateAuthFailed, AuthFailures: maxAuthFailures})
+	}
+	for i := 0; i < users; i++ {
+		select {
+		case settings := <-notified:
+			if settings.PausedReason == "" {
+				t.Errorf("[%s] notification without pause reason", settings.TelegramID)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d watchers have been paused", i, users)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	if n := atomic.LoadInt32(&recorded); n != users {
+		t.Errorf("OnPause called %d times, want %d", n, users)
+	}
+
+	for id := range watchers {
+		status, _ := s.Status(id)
+		if !status.Paused {
+			t.Errorf("[%s] status = %+v, want paused", id, status)
+		}
+		var stored database.UserSettings
+		db.First(&stored, "telegram_id = ?", id)
+		if stored.PausedReason != status.PauseReason {
+			t.Errorf("[%s] stored pause reason = %q, want %q", id, stored.PausedReason, status.PauseReason)
+		}
+	}
+}
@@ -1,43 +1,153 @@
 package telegram
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/NicoNex/echotron/v3"
 	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"github.com/sknr/go-coinbasepro-notifier/internal/ratelimit"
+	"github.com/sknr/go-coinbasepro-notifier/internal/role"
+	"net/http"
 	"os"
 	"runtime/debug"
 	"strconv"
+	"time"
 )
 
+const (
+	// messagesPerSecond stays below the telegram limit of 30 messages per second for bulk notifications
+	messagesPerSecond = 25
+	// requestTimeout limits the duration of a single request to the telegram api
+	requestTimeout = 30 * time.Second
+)
+
+// apiURL is the base URL of the telegram bot api, replaced within tests
+var apiURL = "https://api.telegram.org/bot"
+
+// client sends all messages, so that a single request cannot hang forever
+var client = &http.Client{Timeout: requestTimeout}
+
+// limiter is shared by all push messages
+var limiter = ratelimit.New(messagesPerSecond, messagesPerSecond)
+
+// sendMessageRequest is the body of the sendMessage method, containing the supported message options
+type sendMessageRequest struct {
+	ChatID                   int64                    `json:"chat_id"`
+	Text                     string                   `json:"text"`
+	ParseMode                echotron.ParseMode       `json:"parse_mode,omitempty"`
+	Entities                 []echotron.MessageEntity `json:"entities,omitempty"`
+	DisableWebPagePreview    bool                     `json:"disable_web_page_preview,omitempty"`
+	DisableNotification      bool                     `json:"disable_notification,omitempty"`
+	ProtectContent           bool                     `json:"protect_content,omitempty"`
+	ReplyToMessageID         int                      `json:"reply_to_message_id,omitempty"`
+	AllowSendingWithoutReply bool                     `json:"allow_sending_without_reply,omitempty"`
+	ReplyMarkup              echotron.ReplyMarkup     `json:"reply_markup,omitempty"`
+}
+
+// Send sends a telegram message to the user with given chatID as soon as the rate limit permits it.
+// In contrast to the push message functions, the error is returned to the caller.
+// The request is aborted as soon as ctx is done, so a message reported as failed is not delivered afterwards.
+func Send(ctx context.Context, chatID, message string, opts *echotron.MessageOptions) error {
+	cID, err := strconv.ParseInt(chatID, 10, 64)
+	if err != nil {
+		return err
+	}
+	if err = limiter.Wait(ctx); err != nil {
+		return err
+	}
+
+	// echotron does not accept a context, so the request is sent directly
+	body := sendMessageRequest{ChatID: cID, Text: message}
+	if opts != nil {
+		body.ParseMode = opts.ParseMode
+		body.Entities = opts.Entities
+		body.DisableWebPagePreview = opts.DisableWebPagePreview
+		body.DisableNotification = opts.DisableNotification
+		body.ProtectContent = opts.ProtectContent
+		body.ReplyToMessageID = opts.ReplyToMessageID
+		body.AllowSendingWithoutReply = opts.AllowSendingWithoutReply
+		body.ReplyMarkup = opts.ReplyMarkup
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL+os.Getenv("TELEGRAM_TOKEN")+"/sendMessage", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var res echotron.APIResponseMessage
+	if err = json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return err
+	}
+	if !res.Ok {
+		return fmt.Errorf("telegram api error %d: %s", res.ErrorCode, res.Description)
+	}
+	return nil
+}
+
 // SendPushMessage sends a telegram message to the user with given chatID
 func SendPushMessage(chatID, message string) {
-	botToken := os.Getenv("TELEGRAM_TOKEN")
-	api := echotron.NewAPI(botToken)
-	cID, err := strconv.ParseInt(chatID, 10, 64)
-	logger.LogErrorIfExists(err)
 	if message != "" {
-		_, err = api.SendMessage(message, cID, nil)
-		logger.LogErrorIfExists(err, chatID)
+		logger.LogErrorIfExists(Send(context.Background(), chatID, message, nil), chatID)
 	}
 }
 
-// SendAdminPushMessage sends an telegram message to the admin only
-func SendAdminPushMessage(message string) {
-	adminChatID := os.Getenv("TELEGRAM_ADMIN_CHAT_ID")
-	if adminChatID == "" {
-		logger.LogWarn("Missing env var \"TELEGRAM_ADMIN_CHAT_ID\" -> Cannot send admin push message")
+// SendPushMessageWithMarkup sends a telegram message with inline buttons to the user with given chatID
+func SendPushMessageWithMarkup(chatID, message string, markup echotron.InlineKeyboardMarkup) {
+	if message != "" {
+		logger.LogErrorIfExists(Send(context.Background(), chatID, message, &echotron.MessageOptions{ReplyMarkup: markup}), chatID)
+	}
+}
+
+// roleRecipients resolves the telegram IDs of all users with at least the given role
+var roleRecipients func(min role.Role) []string
+
+// SetRoleRecipients registers the function which resolves the recipients of role based push messages.
+// Until then, those messages are sent to the chat configured by TELEGRAM_ADMIN_CHAT_ID.
+func SetRoleRecipients(fn func(min role.Role) []string) {
+	roleRecipients = fn
+}
+
+// SendRolePushMessage sends a telegram message to all users with at least the given role
+func SendRolePushMessage(min role.Role, message string) {
+	SendRoleMessage(context.Background(), min, message)
+}
+
+// SendRoleMessage sends a telegram message to all users with at least the given role, until ctx is done.
+// Errors are logged.
+func SendRoleMessage(ctx context.Context, min role.Role, message string) {
+	var chatIDs []string
+	if roleRecipients != nil {
+		chatIDs = roleRecipients(min)
+	} else if adminChatID := os.Getenv("TELEGRAM_ADMIN_CHAT_ID"); adminChatID != "" {
+		chatIDs = []string{adminChatID}
+	}
+	if len(chatIDs) == 0 {
+		logger.LogWarnf("No recipients with role %q -> Cannot send push message", min)
 		return
 	}
-	botToken := os.Getenv("TELEGRAM_TOKEN")
-	api := echotron.NewAPI(botToken)
-	cID, err := strconv.ParseInt(adminChatID, 10, 64)
-	logger.LogErrorIfExists(err)
-	if message != "" {
-		_, err = api.SendMessage(message, cID, nil)
-		logger.LogErrorIfExists(err, adminChatID)
+	for _, chatID := range chatIDs {
+		if message != "" {
+			logger.LogErrorIfExists(Send(ctx, chatID, message, nil), chatID)
+		}
 	}
 }
 
+// SendAdminPushMessage sends an telegram message to the admins only
+func SendAdminPushMessage(message string) {
+	SendRolePushMessage(role.Admin, message)
+}
+
 // SendAdminPushMessageWhenPanic sends a push message on application panic
 func SendAdminPushMessageWhenPanic() {
 	if err := recover(); err != nil {
@@ -0,0 +1,84 @@
+package telegram
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/NicoNex/echotron/v3"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestAPI redirects the requests to the given handler
+func newTestAPI(t *testing.T, handler http.HandlerFunc) {
+	server := httptest.NewServer(handler)
+	previous := apiURL
+	apiURL = server.URL + "/bot"
+	t.Cleanup(func() {
+		apiURL = previous
+		server.Close()
+	})
+}
+
+func TestSend(t *testing.T) {
+	var got sendMessageRequest
+	var markup struct {
+		ReplyMarkup echotron.InlineKeyboardMarkup `json:"reply_markup"`
+	}
+	newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		var raw json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+			t.Error(err)
+		}
+		_ = json.Unmarshal(raw, &got)
+		_ = json.Unmarshal(raw, &markup)
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":1}}`))
+	})
+
+	opts := &echotron.MessageOptions{ReplyMarkup: echotron.InlineKeyboardMarkup{
+		InlineKeyboard: [][]echotron.InlineKeyboardButton{{{Text: "Mute", CallbackData: "mute"}}},
+	}}
+	if err := Send(context.Background(), "42", "Hello", opts); err != nil {
+		t.Fatal(err)
+	}
+	if got.ChatID != 42 || got.Text != "Hello" {
+		t.Errorf("request = %+v", got)
+	}
+	if len(markup.ReplyMarkup.InlineKeyboard) != 1 || markup.ReplyMarkup.InlineKeyboard[0][0].CallbackData != "mute" {
+		t.Errorf("reply markup = %+v", markup.ReplyMarkup)
+	}
+}
+
+func TestSendAPIError(t *testing.T) {
+	newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`))
+	})
+	if err := Send(context.Background(), "42", "Hello", nil); err == nil {
+		t.Error("Send() = nil, want the api error")
+	}
+}
+
+func TestSendCanceled(t *testing.T) {
+	aborted := make(chan struct{})
+	newTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices the aborted request once the body has been read
+		_, _ = io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+		close(aborted)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := Send(ctx, "42", "Hello", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Send() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Error("request still pending after Send returned")
+	}
+}
@@ -0,0 +1,146 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// Scopes of a personal access token
+const (
+	ScopeRead  = "read"  // Read the profile, settings, orders and watcher status
+	ScopeWrite = "write" // Change the settings and delete the profile
+)
+
+const (
+	// prefix identifies the tokens of this app, e.g. in secret scanners
+	prefix       = "cbn_"
+	randomBytes  = 32
+	displayChars = 8
+	// maxTokensPerUser limits the number of tokens a user can create
+	maxTokensPerUser = 10
+	// lastUsedPrecision avoids a database write on every request
+	lastUsedPrecision = time.Minute
+)
+
+var (
+	ErrInvalidToken  = errors.New("invalid token")
+	ErrInvalidScope  = errors.New("invalid scope")
+	ErrInvalidName   = errors.New("token name must not be empty")
+	ErrTooManyTokens = errors.New("maximum number of tokens reached")
+	ErrNotFound      = errors.New("token not found")
+)
+
+// Store manages the personal access tokens of the users
+type Store struct {
+	db *gorm.DB
+}
+
+func NewStore(db *gorm.DB) *Store {
+	return &Store{db: db}
+}
+
+// Create generates a new token with the given name and scopes.
+// The plaintext token is only returned once and cannot be recovered afterwards.
+func (s *Store) Create(telegramID, name string, scopes []string) (string, database.APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", database.APIToken{}, ErrInvalidName
+	}
+	if len(scopes) == 0 {
+		return "", database.APIToken{}, ErrInvalidScope
+	}
+	for _, scope := range scopes {
+		if scope != ScopeRead && scope != ScopeWrite {
+			return "", database.APIToken{}, ErrInvalidScope
+		}
+	}
+	var count int64
+	s.db.Model(&database.APIToken{}).Where("telegram_id = ?", telegramID).Count(&count)
+	if count >= maxTokensPerUser {
+		return "", database.APIToken{}, ErrTooManyTokens
+	}
+
+	b := make([]byte, randomBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", database.APIToken{}, err
+	}
+	plaintext := prefix + base64.RawURLEncoding.EncodeToString(b)
+	t := database.APIToken{
+		TelegramID: telegramID,
+		Name:       name,
+		Prefix:     plaintext[:len(prefix)+displayChars],
+		Hash:       hash(plaintext),
+		Scopes:     strings.Join(scopes, ","),
+	}
+	if err := s.db.Create(&t).Error; err != nil {
+		return "", database.APIToken{}, err
+	}
+	return plaintext, t, nil
+}
+
+// List returns all tokens of the user, newest first
+func (s *Store) List(telegramID string) []database.APIToken {
+	var tokens []database.APIToken
+	err := s.db.Where("telegram_id = ?", telegramID).Order("created_at DESC").Find(&tokens).Error
+	logger.LogErrorIfExists(err, telegramID)
+	return tokens
+}
+
+// Revoke deletes the token with the given ID, if it belongs to the user
+func (s *Store) Revoke(telegramID string, id uint) (database.APIToken, error) {
+	var t database.APIToken
+	s.db.Where("id = ? AND telegram_id = ?", id, telegramID).Limit(1).Find(&t)
+	if t.ID == 0 {
+		return t, ErrNotFound
+	}
+	return t, s.db.Delete(&t).Error
+}
+
+// RevokeAll deletes all tokens of the user
+func (s *Store) RevokeAll(telegramID string) {
+	logger.LogErrorIfExists(s.db.Where("telegram_id = ?", telegramID).Delete(&database.APIToken{}).Error, telegramID)
+}
+
+// Authenticate returns the token matching the given plaintext token and updates its last used time
+func (s *Store) Authenticate(plaintext string) (database.APIToken, error) {
+	var t database.APIToken
+	if !strings.HasPrefix(plaintext, prefix) {
+		return t, ErrInvalidToken
+	}
+	// The lookup by hash does not leak timing information about the plaintext token
+	s.db.Where("hash = ?", hash(plaintext)).Limit(1).Find(&t)
+	if t.ID == 0 {
+		return t, ErrInvalidToken
+	}
+
+	now := time.Now()
+	if now.Sub(t.LastUsedAt) >= lastUsedPrecision {
+		t.LastUsedAt = now
+		err := s.db.Model(&t).Update("last_used_at", now).Error
+		logger.LogErrorIfExists(err, t.TelegramID)
+	}
+	return t, nil
+}
+
+// HasScope returns true if the token has been granted the given scope
+func HasScope(t database.APIToken, scope string) bool {
+	for _, s := range strings.Split(t.Scopes, ",") {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func hash(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
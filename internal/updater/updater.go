@@ -18,6 +18,7 @@ type Updater struct {
 func New() *Updater {
 	u := &Updater{
 		client: coinbasepro.NewClient(),
+		ticker: time.NewTicker(6 * time.Hour),
 	}
 	u.productIDs = u.getProductIDsFromCoinbase()
 	// Start background task for updating
@@ -26,9 +27,6 @@ func New() *Updater {
 }
 
 func (u *Updater) Update() {
-	if u.ticker == nil {
-		u.ticker = time.NewTicker(6 * time.Hour)
-	}
 	for range u.ticker.C {
 		u.mu.Lock()
 		u.productIDs = u.getProductIDsFromCoinbase()
@@ -38,7 +36,6 @@ func (u *Updater) Update() {
 
 func (u *Updater) Stop() {
 	u.ticker.Stop()
-	u.ticker = nil
 }
 
 func (u *Updater) GetProductIDs() []string {
@@ -0,0 +1,83 @@
+package view
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// layoutFile contains the "layout" template, which renders the "title" and "content" templates of a page
+const layoutFile = "layout.html"
+
+// Templates renders the pages of the web interface. Each page is a *.html file, which is parsed together with the layout.
+type Templates struct {
+	fsys   fs.FS
+	reload bool
+
+	mu    sync.RWMutex
+	pages map[string]*template.Template
+}
+
+// New parses all pages of fsys. If reload is set, the pages are parsed again on every render,
+// so changes on disk are visible without restarting the app.
+func New(fsys fs.FS, reload bool) (*Templates, error) {
+	t := &Templates{fsys: fsys, reload: reload}
+	if err := t.parse(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Render executes the page with the given name, e.g. "profile" for profile.html.
+// The page is rendered into a buffer first, so nothing is written to w on error.
+func (t *Templates) Render(w http.ResponseWriter, name string, data interface{}) error {
+	if t.reload {
+		if err := t.parse(); err != nil {
+			return err
+		}
+	}
+
+	t.mu.RLock()
+	page, ok := t.pages[name]
+	t.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("template %q not found", name)
+	}
+
+	var buf bytes.Buffer
+	if err := page.ExecuteTemplate(&buf, "layout", data); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+func (t *Templates) parse() error {
+	files, err := fs.Glob(t.fsys, "*.html")
+	if err != nil {
+		return err
+	}
+
+	pages := make(map[string]*template.Template)
+	for _, file := range files {
+		if file == layoutFile {
+			continue
+		}
+		page, err := template.ParseFS(t.fsys, layoutFile, file)
+		if err != nil {
+			return err
+		}
+		pages[strings.TrimSuffix(file, path.Ext(file))] = page
+	}
+
+	t.mu.Lock()
+	t.pages = pages
+	t.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,182 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/jpillora/backoff"
+	"github.com/preichenberger/go-coinbasepro/v2"
+	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	handshakeTimeout  = 10 * time.Second
+	writeTimeout      = 5 * time.Second
+	keepAliveInterval = 10 * time.Second
+	readTimeout       = 3 * keepAliveInterval // Connection is considered dead if not even a pong was received
+
+	reconnectIntervalMin    = 2 * time.Second
+	reconnectIntervalMax    = 256 * time.Second
+	reconnectIntervalFactor = 2
+)
+
+var dialer = &websocket.Dialer{
+	Proxy:            websocket.DefaultDialer.Proxy,
+	HandshakeTimeout: handshakeTimeout,
+}
+
+// run keeps the websocket connection alive until ctx is canceled.
+// Every (re)connect is followed by a new subscription to the user channel.
+func (w *CoinbaseProWatcher) run(ctx context.Context) {
+	wsURL := os.Getenv("COINBASE_PRO_WEBSOCKET_URL")
+	if wsURL == "" {
+		wsURL = CoinbaseProWebSocketURL
+	}
+
+	b := &backoff.Backoff{
+		Min:    reconnectIntervalMin,
+		Max:    reconnectIntervalMax,
+		Factor: reconnectIntervalFactor,
+		Jitter: true,
+	}
+
+	for attempt := 0; ctx.Err() == nil; attempt++ {
+		if attempt > 0 {
+			w.recordReconnect()
+		}
+		// Keep the auth_failed state during the backoff, so that the failure stays visible
+		if w.getState() != StateAuthFailed {
+			w.setState(StateConnecting)
+		}
+		// Every dial is followed by a subscription, so both are covered by the shared limiter
+		if w.limiter != nil && w.limiter.Wait(ctx) != nil {
+			return
+		}
+		err := w.connect(ctx, wsURL, b)
+		if ctx.Err() != nil {
+			return
+		}
+		logger.LogWarnf("[%s] Websocket connection lost: %v", w.userSettings.TelegramID, err)
+		w.recordError(err.Error())
+		if w.getState() != StateAuthFailed {
+			w.setState(StateBackoff)
+		}
+		if !sleep(ctx, b.Duration()) {
+			return
+		}
+	}
+}
+
+// connect dials the websocket, subscribes to the user channel and reads messages until the connection breaks, a reconnect is forced or ctx is canceled
+func (w *CoinbaseProWatcher) connect(ctx context.Context, wsURL string, b *backoff.Backoff) error {
+	conn, _, err := dialer.DialContext(ctx, wsURL, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	w.setConnected(true)
+	defer w.setConnected(false)
+
+	if err = w.subscribe(conn); err != nil {
+		return err
+	}
+	// Drain a pending reconnect request of a previous connection
+	select {
+	case <-w.reconnect:
+	default:
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	// Send pings and close the connection on cancellation, which unblocks the reader below
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(keepAliveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-w.reconnect:
+				_ = conn.Close()
+				return
+			case <-ctx.Done():
+				_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(writeTimeout))
+				_ = conn.Close()
+				return
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+					_ = conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(readTimeout))
+	})
+
+	// The reader only enqueues the raw messages, so that it never waits for the following pipeline stages
+	for {
+		if err = conn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+			return err
+		}
+		var message coinbasepro.Message
+		if err = conn.ReadJSON(&message); err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				return errors.New("connection closed by server")
+			}
+			return err
+		}
+		if message.Type == MessageTypeSubscriptions {
+			// Subscription was successful, so the next reconnect starts with the minimal interval again
+			b.Reset()
+		}
+		w.queue.raw.Push(message)
+	}
+}
+
+// subscribe sends the signed subscribe message for the user channel
+func (w *CoinbaseProWatcher) subscribe(conn *websocket.Conn) error {
+	subscribeMessage := coinbasepro.Message{
+		Type: MessageTypeSubscribe,
+		Channels: []coinbasepro.MessageChannel{
+			{
+				Name:       ChannelTypeUser,
+				ProductIds: w.updater.GetProductIDs(),
+			},
+		},
+	}
+
+	subscribeMessageSigned, err := subscribeMessage.Sign(w.userSettings.APISecret, w.userSettings.APIKey, w.userSettings.APIPassphrase)
+	if err != nil {
+		return err
+	}
+
+	if err = conn.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
+		return err
+	}
+	return conn.WriteJSON(subscribeMessageSigned)
+}
+
+// sleep waits for the given duration and returns false if ctx has been canceled in the meantime
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,105 @@
+package watcher
+
+import (
+	"github.com/sknr/go-coinbasepro-notifier/internal/queue"
+	"time"
+)
+
+// State describes the connection state of a watcher
+type State string
+
+const (
+	StateConnecting State = "connecting"
+	StateSubscribed State = "subscribed"
+	StateAuthFailed State = "auth_failed"
+	StateBackoff    State = "backoff"
+	StateStopped    State = "stopped"
+)
+
+// Status is a snapshot of the watcher's health
+type Status struct {
+	State         State         `json:"state"`
+	Connected     bool          `json:"connected"`
+	Since         time.Time     `json:"since"`           // Time of the last state change
+	LastMessageAt time.Time     `json:"last_message_at"` // Time of the last message received from the websocket
+	LastError     string        `json:"last_error"`
+	LastErrorAt   time.Time     `json:"last_error_at"`
+	ErrorCount    int           `json:"error_count"`
+	AuthFailures  int           `json:"auth_failures"` // Number of consecutive authentication failures
+	Reconnects    int           `json:"reconnects"`
+	Queues        []queue.Stats `json:"queues"`
+}
+
+// Status returns a snapshot of the current watcher status
+func (w *CoinbaseProWatcher) Status() Status {
+	w.statusMu.RLock()
+	s := w.status
+	w.statusMu.RUnlock()
+	s.Queues = w.QueueStats()
+	return s
+}
+
+// OnStateChange registers a callback which gets called on every state change.
+// It must be registered before the watcher is started.
+func (w *CoinbaseProWatcher) OnStateChange(fn func(s Status)) {
+	w.onStateChange = fn
+}
+
+// setState changes the state of the watcher and notifies the registered callback
+func (w *CoinbaseProWatcher) setState(state State) {
+	w.statusMu.Lock()
+	changed := w.status.State != state
+	if changed {
+		w.status.State = state
+		w.status.Since = time.Now()
+	}
+	switch state {
+	case StateSubscribed:
+		w.status.AuthFailures = 0
+	case StateAuthFailed:
+		w.status.AuthFailures++
+	}
+	w.statusMu.Unlock()
+
+	// Repeated authentication failures are reported as well, so that the callback can count them
+	if (changed || state == StateAuthFailed) && w.onStateChange != nil {
+		w.onStateChange(w.Status())
+	}
+}
+
+// getState returns the current state of the watcher
+func (w *CoinbaseProWatcher) getState() State {
+	w.statusMu.RLock()
+	defer w.statusMu.RUnlock()
+	return w.status.State
+}
+
+// recordError stores the given error as the last error of the watcher
+func (w *CoinbaseProWatcher) recordError(message string) {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+	w.status.LastError = message
+	w.status.LastErrorAt = time.Now()
+	w.status.ErrorCount++
+}
+
+// recordMessage stores the time of the last received message
+func (w *CoinbaseProWatcher) recordMessage() {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+	w.status.LastMessageAt = time.Now()
+}
+
+// setConnected stores whether the websocket connection is currently established
+func (w *CoinbaseProWatcher) setConnected(connected bool) {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+	w.status.Connected = connected
+}
+
+// recordReconnect increments the reconnect counter
+func (w *CoinbaseProWatcher) recordReconnect() {
+	w.statusMu.Lock()
+	defer w.statusMu.Unlock()
+	w.status.Reconnects++
+}
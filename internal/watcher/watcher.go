@@ -1,82 +1,329 @@
 package watcher
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/NicoNex/echotron/v3"
 	"github.com/preichenberger/go-coinbasepro/v2"
-	"github.com/recws-org/recws"
+	"github.com/sknr/go-coinbasepro-notifier/internal/callback"
 	"github.com/sknr/go-coinbasepro-notifier/internal/database"
 	"github.com/sknr/go-coinbasepro-notifier/internal/logger"
+	"github.com/sknr/go-coinbasepro-notifier/internal/mute"
+	"github.com/sknr/go-coinbasepro-notifier/internal/queue"
+	"github.com/sknr/go-coinbasepro-notifier/internal/ratelimit"
+	"github.com/sknr/go-coinbasepro-notifier/internal/role"
 	"github.com/sknr/go-coinbasepro-notifier/internal/telegram"
 	"github.com/sknr/go-coinbasepro-notifier/internal/updater"
-	"github.com/sknr/go-coinbasepro-notifier/internal/utils"
-	"os"
+	"gorm.io/gorm"
+	"strconv"
+	"sync"
 	"time"
 )
 
 const (
-	CoinbaseProURL          = "https://api.pro.coinbase.com"
 	CoinbaseProWebSocketURL = "wss://ws-feed.pro.coinbase.com"
+
+	// Capacities of the pipeline queues
+	rawQueueSize      = 256
+	persistQueueSize  = 256
+	deliveryQueueSize = 64
 )
 
+// errDropped is recorded for notifications, which have been dropped from the delivery queue
+var errDropped = errors.New("dropped: delivery queue full")
+
+// CoinbaseProWatcher watches the coinbase pro user channel of a single user.
+// Received messages are passed through a pipeline of bounded queues:
+//
+//	reader -> raw -> converter -> persist -> persister
+//	                           -> delivery -> deliverer
+//
+// so that neither the database nor the telegram api can stall the websocket reader.
 type CoinbaseProWatcher struct {
 	client       *coinbasepro.Client
-	ws           *recws.RecConn
+	db           *gorm.DB
 	userSettings database.UserSettings // Current user settings
-	channel      channel
+	queue        queues
 	updater      *updater.Updater
+	limiter      *ratelimit.TokenBucket // Shared limiter for websocket dials and subscriptions
+	mutes        *mute.Store
+
+	ctx       context.Context // Canceled to close the websocket connection
+	cancel    context.CancelFunc
+	abortCtx  context.Context // Canceled to abort the pipeline stages without draining the queues
+	abort     context.CancelFunc
+	wg        sync.WaitGroup
+	done      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+	reconnect chan struct{} // Forces the current websocket connection to be closed
+
+	status        Status
+	statusMu      sync.RWMutex
+	onStateChange func(s Status)
+}
+
+type queues struct {
+	raw      *queue.Queue // coinbasepro.Message as received from the websocket
+	persist  *queue.Queue // OrderMessage which should be stored in the database
+	delivery *queue.Queue // notification which should be sent via telegram
 }
 
-type channel struct {
-	order     chan OrderMessage
-	terminate chan struct{}
+// notification is a telegram message waiting for delivery
+type notification struct {
+	ProductID string
+	Text      string
+	Admin     bool // Send the message to the support team instead of the user
 }
 
-func New(userSettings database.UserSettings, updater *updater.Updater) *CoinbaseProWatcher {
+func New(userSettings database.UserSettings, updater *updater.Updater, db *gorm.DB, limiter *ratelimit.TokenBucket) *CoinbaseProWatcher {
 	c := coinbasepro.NewClient()
 
-	return &CoinbaseProWatcher{
+	w := &CoinbaseProWatcher{
 		client:       c,
-		ws:           nil,
+		db:           db,
 		updater:      updater,
+		limiter:      limiter,
+		mutes:        mute.NewStore(db),
 		userSettings: userSettings,
+		done:         make(chan struct{}),
+		reconnect:    make(chan struct{}, 1),
+		status:       Status{State: StateStopped, Since: time.Now()},
 	}
+	w.abortCtx, w.abort = context.WithCancel(context.Background())
+	w.ctx, w.cancel = context.WithCancel(w.abortCtx)
+	w.queue.raw = queue.New("raw", rawQueueSize, queue.DropOldest).OnDrop(w.logDrop("raw"))
+	w.queue.persist = queue.New("persist", persistQueueSize, queue.DropOldest).OnDrop(w.logDrop("persist"))
+	w.queue.delivery = queue.New("delivery", deliveryQueueSize, queue.DropOldest).OnDrop(w.dropDelivery)
+
+	return w
 }
 
+// Start starts the websocket connection and the pipeline stages in the background.
+// Calling Start more than once or after Stop has no effect.
 func (w *CoinbaseProWatcher) Start() {
-	wsURL := os.Getenv("COINBASE_PRO_WEBSOCKET_URL")
-	if wsURL == "" {
-		wsURL = CoinbaseProWebSocketURL
+	w.startOnce.Do(func() {
+		w.spawn(w.abortCtx, w.convert)
+		w.spawn(w.abortCtx, w.persist)
+		w.spawn(w.abortCtx, w.deliver)
+		w.spawn(w.ctx, func(ctx context.Context) {
+			w.run(ctx)
+			// No more messages will be received, so let the pipeline drain
+			w.queue.raw.Close()
+		})
+		go func() {
+			w.wg.Wait()
+			w.setState(StateStopped)
+			logger.LogInfof("Closed client with ID %q", w.userSettings.TelegramID)
+			close(w.done)
+		}()
+	})
+}
+
+// Stop cancels the watcher immediately and blocks until all of its goroutines have exited.
+// Queued notifications are discarded. It is safe to call Stop multiple times and before Start.
+func (w *CoinbaseProWatcher) Stop() {
+	w.abort()
+	_ = w.Shutdown(context.Background())
+}
+
+// Shutdown closes the websocket connection and waits until all queued messages have been persisted and delivered.
+// If ctx expires before, the pipeline gets canceled and ctx.Err() is returned once all goroutines have exited.
+// It is safe to call Shutdown multiple times and before Start.
+func (w *CoinbaseProWatcher) Shutdown(ctx context.Context) error {
+	w.stopOnce.Do(func() {
+		w.cancel()
+		// Mark the watcher as done if it has never been started
+		w.startOnce.Do(func() {
+			w.abort()
+			close(w.done)
+		})
+	})
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		w.abort()
+		<-w.done
+		return ctx.Err()
 	}
+}
 
-	// Initialize channels
-	w.channel.order = make(chan OrderMessage, 5)
-	w.channel.terminate = make(chan struct{})
+// Done returns a channel which is closed once the watcher has been stopped and all of its goroutines have exited
+func (w *CoinbaseProWatcher) Done() <-chan struct{} {
+	return w.done
+}
 
-	w.ws = recws.New(
-		recws.WithKeepAliveTimeout(10*time.Second),
-		recws.WithReconnectInterval(2*time.Second, 256*time.Second, 2),
-		recws.WithSubscribeHandler(w.subscribeHandler),
-		//recws.WithVerbose(),
-	)
+// spawn runs fn within a goroutine tracked by the watcher's WaitGroup
+func (w *CoinbaseProWatcher) spawn(ctx context.Context, fn func(ctx context.Context)) {
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		fn(ctx)
+	}()
+}
 
-	// Create new WebSocket connection
-	w.ws.Dial(wsURL, nil)
+// QueueStats returns the metrics of all pipeline queues
+func (w *CoinbaseProWatcher) QueueStats() []queue.Stats {
+	var stats []queue.Stats
+	for _, q := range []*queue.Queue{w.queue.raw, w.queue.persist, w.queue.delivery} {
+		if q != nil {
+			stats = append(stats, q.Stats())
+		}
+	}
+	return stats
+}
 
-	// Block until app termination (CTRL-C) or we receive a message on the close channel
+// convert is the pipeline stage which converts raw websocket messages and dispatches them to the next stages.
+// Once the raw queue is closed and drained, the following queues get closed as well.
+func (w *CoinbaseProWatcher) convert(ctx context.Context) {
+	defer w.queue.persist.Close()
+	defer w.queue.delivery.Close()
 	for {
 		select {
-		case <-w.channel.terminate:
-			w.ws.Shutdown()
-			logger.LogInfof("Closing client with ID %q", w.userSettings.TelegramID)
+		case <-ctx.Done():
 			return
-		case orderMessage := <-w.channel.order:
-			telegram.SendPushMessage(w.userSettings.TelegramID, orderMessage.String())
+		case item, ok := <-w.queue.raw.C():
+			if !ok {
+				return
+			}
+			w.queue.raw.Done()
+			w.recordMessage()
+			w.handleWebSocketMessage(item.(coinbasepro.Message))
 		}
 	}
 }
 
-func (w *CoinbaseProWatcher) Stop() {
-	close(w.channel.terminate)
+// persist is the pipeline stage which stores order messages in the database
+func (w *CoinbaseProWatcher) persist(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case item, ok := <-w.queue.persist.C():
+			if !ok {
+				return
+			}
+			w.queue.persist.Done()
+			om := item.(OrderMessage)
+			err := w.db.Create(&database.OrderEvent{
+				TelegramID:    w.userSettings.TelegramID,
+				OrderID:       om.OrderID,
+				Time:          *om.Time,
+				Type:          om.Type,
+				Reason:        om.Reason,
+				ProductID:     om.ProductID,
+				Side:          om.Side,
+				OrderType:     om.OrderType,
+				Price:         om.Price,
+				Size:          om.Size,
+				RemainingSize: om.RemainingSize,
+				Funds:         om.Funds,
+				Sequence:      om.Sequence,
+			}).Error
+			logger.LogErrorIfExists(err, w.userSettings.TelegramID)
+		}
+	}
+}
+
+// deliver is the pipeline stage which sends the notifications via telegram
+func (w *CoinbaseProWatcher) deliver(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case item, ok := <-w.queue.delivery.C():
+			if !ok {
+				return
+			}
+			w.queue.delivery.Done()
+			w.send(ctx, item.(notification))
+		}
+	}
+}
+
+// send delivers the notification unless the product has been muted by the user.
+// Order notifications get buttons attached, in order to mute the product quickly.
+// Waiting for the rate limit and the request itself are canceled with ctx.
+func (w *CoinbaseProWatcher) send(ctx context.Context, n notification) {
+	switch {
+	case n.Admin:
+		telegram.SendRoleMessage(ctx, role.Support, n.Text)
+	case n.ProductID == "":
+		w.recordNotification(n, telegram.Send(ctx, w.userSettings.TelegramID, n.Text, nil))
+	case w.mutes.IsMuted(w.userSettings.TelegramID, n.ProductID):
+		logger.LogDebugf("[%s] Notification for muted product %s suppressed", w.userSettings.TelegramID, n.ProductID)
+	default:
+		opts := &echotron.MessageOptions{ReplyMarkup: w.muteButtons(n.ProductID)}
+		w.recordNotification(n, telegram.Send(ctx, w.userSettings.TelegramID, n.Text, opts))
+	}
+}
+
+// recordNotification stores the delivery result of a notification in the notification log
+func (w *CoinbaseProWatcher) recordNotification(n notification, err error) {
+	entry := database.Notification{
+		TelegramID: w.userSettings.TelegramID,
+		ProductID:  n.ProductID,
+		Text:       n.Text,
+		Delivered:  err == nil,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+		logger.LogErrorIfExists(err, w.userSettings.TelegramID)
+	}
+	logger.LogErrorIfExists(w.db.Create(&entry).Error, w.userSettings.TelegramID)
+}
+
+// muteButtons creates the inline buttons for muting the notifications of the given product
+func (w *CoinbaseProWatcher) muteButtons(productID string) echotron.InlineKeyboardMarkup {
+	chatID, err := strconv.ParseInt(w.userSettings.TelegramID, 10, 64)
+	if err != nil {
+		return echotron.InlineKeyboardMarkup{}
+	}
+	var row []echotron.InlineKeyboardButton
+	for _, d := range []string{"1h", "1d"} {
+		row = append(row, echotron.InlineKeyboardButton{
+			Text:         fmt.Sprintf("🔕 Mute %s for %s", productID, d),
+			CallbackData: callback.Encode(chatID, callback.ActionMute, productID+":"+d),
+		})
+	}
+	return echotron.InlineKeyboardMarkup{InlineKeyboard: [][]echotron.InlineKeyboardButton{row}}
+}
+
+// forceReconnect closes the current websocket connection, which leads to a reconnect after the backoff interval
+func (w *CoinbaseProWatcher) forceReconnect() {
+	select {
+	case w.reconnect <- struct{}{}:
+	default:
+	}
+}
+
+// notify queues a notification for delivery
+func (w *CoinbaseProWatcher) notify(n notification) {
+	if n.Text == "" {
+		return
+	}
+	w.queue.delivery.Push(n)
+}
+
+// logDrop returns an OnDrop callback which logs dropped items of the given queue
+func (w *CoinbaseProWatcher) logDrop(name string) func(item interface{}) {
+	return func(item interface{}) {
+		logger.LogWarnf("[%s] Queue %q is full -> dropped item: %v", w.userSettings.TelegramID, name, item)
+	}
+}
+
+// dropDelivery is the OnDrop callback of the delivery queue.
+// Dropped user notifications are recorded as undelivered within the notification log.
+func (w *CoinbaseProWatcher) dropDelivery(item interface{}) {
+	w.logDrop("delivery")(item)
+	n := item.(notification)
+	if n.Admin || (n.ProductID != "" && w.mutes.IsMuted(w.userSettings.TelegramID, n.ProductID)) {
+		return
+	}
+	w.recordNotification(n, errDropped)
 }
 
 func (w *CoinbaseProWatcher) handleWebSocketMessage(message coinbasepro.Message) {
@@ -86,12 +333,21 @@ func (w *CoinbaseProWatcher) handleWebSocketMessage(message coinbasepro.Message)
 		w.handleOrderMessage(message)
 	case MessageTypeError:
 		logger.LogWarn("ErrorMessage", w.userSettings.TelegramID, message.Message)
+		w.recordError(message.Message)
 		if message.Message == "Authentication Failed" {
-			telegram.SendPushMessage(w.userSettings.TelegramID, "Coinbase Pro authentication failed. Please check your API-Settings, in order to get informed about your order changes.")
+			repeated := w.Status().AuthFailures > 0
+			w.setState(StateAuthFailed)
+			w.forceReconnect()
+			// Only inform about the first of several consecutive failures
+			if repeated {
+				break
+			}
+			w.notify(notification{Text: "Coinbase Pro authentication failed. Please check your API-Settings, in order to get informed about your order changes."})
 		}
-		telegram.SendAdminPushMessage(fmt.Sprintf("Received an error message for user %s (%s)\nErrorMessage: %s", w.userSettings.FirstName, w.userSettings.TelegramID, message.Message))
+		w.notify(notification{Admin: true, Text: fmt.Sprintf("Received an error message for user %s (%s)\nErrorMessage: %s", w.userSettings.FirstName, w.userSettings.TelegramID, message.Message)})
 	case MessageTypeSubscriptions:
 		logger.LogInfo("Successfully subscribed to channels", w.userSettings.TelegramID, message.Channels)
+		w.setState(StateSubscribed)
 	case MessageTypeStatus:
 		logger.LogInfo("Status-Message", w.userSettings.TelegramID, message)
 	default:
@@ -100,7 +356,7 @@ func (w *CoinbaseProWatcher) handleWebSocketMessage(message coinbasepro.Message)
 	}
 }
 
-// handleOrderMessage converts a coinbasepro.Message into an OrderMessage
+// handleOrderMessage converts a coinbasepro.Message into an OrderMessage and passes it to the persist and delivery stages
 func (w *CoinbaseProWatcher) handleOrderMessage(message coinbasepro.Message) {
 	messageTime := message.Time.Time()
 	orderMessage := OrderMessage{
@@ -127,53 +383,6 @@ func (w *CoinbaseProWatcher) handleOrderMessage(message coinbasepro.Message) {
 		ProfileID:     message.ProfileID,
 	}
 
-	w.channel.order <- orderMessage
-}
-
-func (w *CoinbaseProWatcher) subscribeHandler() error {
-	var (
-		subscribeMessage       coinbasepro.Message
-		subscribeMessageSigned coinbasepro.SignedMessage
-		messageChannels        []coinbasepro.MessageChannel
-		err                    error
-	)
-
-	messageChannels = []coinbasepro.MessageChannel{
-		{
-			Name:       ChannelTypeUser,
-			ProductIds: w.updater.GetProductIDs(),
-		},
-	}
-
-	subscribeMessage = coinbasepro.Message{
-		Type:     MessageTypeSubscribe,
-		Channels: messageChannels,
-	}
-
-	subscribeMessageSigned, err = subscribeMessage.Sign(w.userSettings.APISecret, w.userSettings.APIKey, w.userSettings.APIPassphrase)
-	if utils.HasError(err) {
-		logger.LogError(err)
-		return nil
-	}
-
-	err = w.ws.WriteJSON(subscribeMessageSigned)
-	if utils.HasError(err) {
-		logger.LogError(err)
-		return nil
-	}
-
-	// Start receiving messages within a separate go-routine
-	go func() {
-		for {
-			var message coinbasepro.Message
-			err = w.ws.ReadJSON(&message)
-			if utils.HasError(err) {
-				logger.LogError(err)
-				return
-			}
-			w.handleWebSocketMessage(message)
-		}
-	}()
-
-	return nil
+	w.queue.persist.Push(orderMessage)
+	w.notify(notification{ProductID: orderMessage.ProductID, Text: orderMessage.String()})
 }
@@ -0,0 +1,238 @@
+package watcher
+
+import (
+	"encoding/base64"
+	"github.com/foxever/sqlite"
+	"github.com/gorilla/websocket"
+	"github.com/sknr/go-coinbasepro-notifier/internal/database"
+	"github.com/sknr/go-coinbasepro-notifier/internal/updater"
+	"go.uber.org/goleak"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitTimeout is the maximum time to wait for a state change. It covers the minimal reconnect interval.
+const waitTimeout = 3 * reconnectIntervalMin
+
+// TestMain fails if any goroutine of the watchers or test servers is still running after the tests
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
+
+// feed is a local replacement of the coinbase pro websocket feed
+type feed struct {
+	*httptest.Server
+	connections int32
+}
+
+// newFeed starts a websocket server which confirms every subscription and points the watchers to it.
+// If reject is true, every websocket handshake fails.
+func newFeed(t *testing.T, reject bool) *feed {
+	f := &feed{}
+	upgrader := websocket.Upgrader{}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reject {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		atomic.AddInt32(&f.connections, 1)
+
+		var subscribe map[string]interface{}
+		if err = conn.ReadJSON(&subscribe); err != nil {
+			return
+		}
+		if err = conn.WriteJSON(map[string]interface{}{"type": MessageTypeSubscriptions}); err != nil {
+			return
+		}
+		// Keep the connection open until the client closes it
+		for {
+			if _, _, err = conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(f.Close)
+	t.Setenv("COINBASE_PRO_WEBSOCKET_URL", "ws"+strings.TrimPrefix(f.URL, "http"))
+	return f
+}
+
+// newTestWatcher creates a watcher with valid looking credentials and without a limiter.
+// The database is not needed, because the feed never sends order messages.
+func newTestWatcher() *CoinbaseProWatcher {
+	return New(database.UserSettings{
+		TelegramID:    "1",
+		APIKey:        "key",
+		APISecret:     base64.StdEncoding.EncodeToString([]byte("secret")),
+		APIPassphrase: "passphrase",
+	}, &updater.Updater{}, nil, nil)
+}
+
+// waitFor polls cond until it returns true or waitTimeout expires
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(waitTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitDone fails if the watcher's done channel is not closed within waitTimeout
+func waitDone(t *testing.T, w *CoinbaseProWatcher) {
+	t.Helper()
+	select {
+	case <-w.Done():
+	case <-time.After(waitTimeout):
+		t.Fatal("Done() has not been closed")
+	}
+	if s := w.Status(); s.State != StateStopped || s.Connected {
+		t.Errorf("status after stop = %s (connected %v), want %s", s.State, s.Connected, StateStopped)
+	}
+}
+
+// subscribed returns true if the watcher is connected and subscribed
+func subscribed(w *CoinbaseProWatcher) func() bool {
+	return func() bool {
+		s := w.Status()
+		return s.State == StateSubscribed && s.Connected
+	}
+}
+
+// connectionGoroutines counts the goroutines running the websocket reader and the pinger
+func connectionGoroutines() (readers, pingers int) {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+	for _, g := range strings.Split(string(buf), "\n\n") {
+		switch {
+		case strings.Contains(g, "(*CoinbaseProWatcher).connect.func"):
+			pingers++
+		case strings.Contains(g, "(*CoinbaseProWatcher).connect("):
+			readers++
+		}
+	}
+	return readers, pingers
+}
+
+func TestStopBeforeStart(t *testing.T) {
+	w := newTestWatcher()
+	w.Stop()
+	waitDone(t, w)
+
+	// Starting a stopped watcher has no effect
+	w.Start()
+	if readers, pingers := connectionGoroutines(); readers != 0 || pingers != 0 {
+		t.Errorf("%d readers and %d pingers running after Start on a stopped watcher", readers, pingers)
+	}
+}
+
+func TestStopTwice(t *testing.T) {
+	newFeed(t, false)
+	w := newTestWatcher()
+	w.Start()
+	waitFor(t, "subscription", subscribed(w))
+
+	w.Stop()
+	w.Stop()
+	waitDone(t, w)
+}
+
+func TestStopWhileConnected(t *testing.T) {
+	newFeed(t, false)
+	w := newTestWatcher()
+	w.Start()
+	waitFor(t, "subscription", subscribed(w))
+
+	w.Stop()
+	waitDone(t, w)
+	if readers, pingers := connectionGoroutines(); readers != 0 || pingers != 0 {
+		t.Errorf("%d readers and %d pingers still running after Stop", readers, pingers)
+	}
+}
+
+func TestStopDuringBackoff(t *testing.T) {
+	newFeed(t, true)
+	w := newTestWatcher()
+	w.Start()
+	waitFor(t, "backoff", func() bool { return w.Status().State == StateBackoff })
+
+	// Stop must not wait for the backoff interval to expire
+	start := time.Now()
+	w.Stop()
+	if elapsed := time.Since(start); elapsed >= reconnectIntervalMin {
+		t.Errorf("Stop took %v during the backoff", elapsed)
+	}
+	waitDone(t, w)
+}
+
+func TestForcedReconnect(t *testing.T) {
+	f := newFeed(t, false)
+	w := newTestWatcher()
+	w.Start()
+	defer w.Stop()
+	waitFor(t, "subscription", subscribed(w))
+
+	w.forceReconnect()
+	waitFor(t, "reconnect", func() bool {
+		return w.Status().Reconnects == 1 && atomic.LoadInt32(&f.connections) == 2 && subscribed(w)()
+	})
+
+	// The reader and pinger of the first connection must have exited
+	if readers, pingers := connectionGoroutines(); readers != 1 || pingers != 1 {
+		t.Errorf("%d readers and %d pingers running after a reconnect, want 1 each", readers, pingers)
+	}
+
+	w.Stop()
+	waitDone(t, w)
+}
+
+func TestDroppedNotificationsAreRecorded(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.AutoMigrate(&database.Notification{}, &database.Mute{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+
+	// The watcher is not started, so that the delivery queue is not consumed
+	w := New(database.UserSettings{TelegramID: "1"}, &updater.Updater{}, db, nil)
+	w.notify(notification{Text: "Support only", Admin: true})
+	for i := 1; i <= deliveryQueueSize; i++ {
+		w.notify(notification{ProductID: "BTC-EUR", Text: "Order " + strconv.Itoa(i)})
+	}
+
+	var entries []database.Notification
+	db.Find(&entries)
+	if len(entries) != 0 {
+		t.Fatalf("%d notifications recorded for a dropped support message, want 0", len(entries))
+	}
+
+	w.notify(notification{ProductID: "BTC-EUR", Text: "Order overflow"})
+	db.Find(&entries)
+	if len(entries) != 1 {
+		t.Fatalf("%d notifications recorded, want 1", len(entries))
+	}
+	if e := entries[0]; e.Delivered || e.Error != errDropped.Error() || e.Text != "Order 1" {
+		t.Errorf("recorded notification = %+v, want the oldest one as dropped", e)
+	}
+}
@@ -0,0 +1,9 @@
+// Package static contains the templates and assets of the web interface, which are embedded into the binary
+package static
+
+import "embed"
+
+// FS contains the page templates (*.html) and the assets served below /assets/
+//
+//go:embed *.html assets
+var FS embed.FS